@@ -0,0 +1,102 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuthorityPersistence(t *testing.T) {
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+
+	authority, err := LoadOrCreateAuthority(tempDir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	reloaded, err := LoadOrCreateAuthority(tempDir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !bytes.Equal(authority.CertPEM, reloaded.CertPEM) {
+		t.Error("Certificate authority was regenerated instead of loaded")
+	}
+
+	os.Remove(filepath.Join(tempDir, caKeyFile))
+	if _, err := LoadOrCreateAuthority(tempDir); err == nil {
+		t.Error("Expected an error when the authority key is missing")
+	}
+	if certPEM, _ := ioutil.ReadFile(filepath.Join(tempDir, caCertFile)); !bytes.Equal(certPEM, authority.CertPEM) {
+		t.Error("Certificate authority was replaced when its key was missing")
+	}
+}
+
+func TestIssuedCertificateVerifies(t *testing.T) {
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+
+	authority, _ := LoadOrCreateAuthority(tempDir)
+	cert, err := authority.IssueCertificate([]string{"localhost", "192.168.1.20"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(authority.CertPEM)
+
+	for _, host := range []string{"localhost", "192.168.1.20"} {
+		_, err = leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots})
+		if err != nil {
+			t.Errorf("Certificate did not verify for %s: %s", host, err.Error())
+		}
+	}
+}
+
+func TestDualListener(t *testing.T) {
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+
+	authority, _ := LoadOrCreateAuthority(tempDir)
+	cert, _ := authority.IssueCertificate([]string{"127.0.0.1"})
+
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	dual := NewDualListener(listener, NewTLSConfig(cert))
+	defer dual.Close()
+	go http.Serve(dual, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(authority.CertPEM)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: roots},
+		ForceAttemptHTTP2: true,
+	}}
+
+	for _, scheme := range []string{"http", "https"} {
+		resp, err := client.Get(scheme + "://" + listener.Addr().String())
+		if err != nil {
+			t.Fatalf("%s request failed: %s", scheme, err.Error())
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if scheme == "https" && string(body) != "HTTP/2.0" {
+			t.Errorf("Expected HTTP/2 over https, got %s", body)
+		}
+	}
+
+	dual.Close()
+	if _, err := dual.Accept(); err == nil {
+		t.Error("Expected accepting from a closed listener to fail")
+	}
+}
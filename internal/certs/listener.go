@@ -0,0 +1,104 @@
+package certs
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+)
+
+// tlsRecordHandshake is the first byte sent by a client starting a TLS handshake.
+const tlsRecordHandshake = 0x16
+
+// maxAcceptDelay is the longest wait before accepting connections again after an error.
+const maxAcceptDelay = time.Second
+
+// dualListener accepts both plain and TLS connections on the same port by
+// sniffing the first byte sent by each client.
+type dualListener struct {
+	net.Listener
+	config *tls.Config
+	conns  chan net.Conn
+	done   chan struct{}
+	err    error
+}
+
+// peekedConn is a connection that has had data read ahead into a buffer.
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// NewDualListener wraps the given listener so that connections starting
+// a TLS handshake are served over TLS while others are served as-is.
+func NewDualListener(listener net.Listener, config *tls.Config) net.Listener {
+	dl := &dualListener{
+		Listener: listener,
+		config:   config,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	go dl.acceptLoop()
+	return dl
+}
+
+func (dl *dualListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-dl.conns:
+		return conn, nil
+	case <-dl.done:
+		return nil, dl.err
+	}
+}
+
+func (dl *dualListener) acceptLoop() {
+	var delay time.Duration
+	for {
+		conn, err := dl.Listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			dl.err = err
+			close(dl.done)
+			return
+		}
+
+		// Wait a little longer after each error, such as running out of file descriptors, before trying again
+		if err != nil {
+			if delay *= 2; delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay > maxAcceptDelay {
+				delay = maxAcceptDelay
+			}
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		go dl.sniff(conn)
+	}
+}
+
+func (dl *dualListener) sniff(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	first, err := reader.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	var accepted net.Conn = &peekedConn{Conn: conn, reader: reader}
+	if first[0] == tlsRecordHandshake {
+		accepted = tls.Server(accepted, dl.config)
+	}
+
+	select {
+	case dl.conns <- accepted:
+	case <-dl.done:
+		conn.Close()
+	}
+}
@@ -0,0 +1,158 @@
+package fastcgi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// Record types as defined by the FastCGI specification.
+const (
+	typeBeginRequest = 1
+	typeEndRequest   = 3
+	typeParams       = 4
+	typeStdin        = 5
+	typeStdout       = 6
+	typeStderr       = 7
+)
+
+const (
+	protocolVersion = 1
+	roleResponder   = 1
+	requestID       = 1
+	maxContentSize  = 65535
+	requestTimeout  = 60 * time.Second
+)
+
+// Response holds the output of a completed FastCGI request.
+type Response struct {
+	Stdout []byte
+	Stderr []byte
+}
+
+// Do sends a single request, with the given CGI params and body, to the FastCGI
+// application listening at the given address and waits for its response.
+func Do(network string, address string, params map[string]string, body io.Reader) (*Response, error) {
+	conn, err := net.DialTimeout(network, address, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	writer := bufio.NewWriter(conn)
+	beginBody := []byte{0, roleResponder, 0, 0, 0, 0, 0, 0}
+	if err := writeRecord(writer, typeBeginRequest, beginBody); err != nil {
+		return nil, err
+	}
+
+	if err := writeStream(writer, typeParams, encodeParams(params)); err != nil {
+		return nil, err
+	}
+
+	var stdin []byte
+	if body != nil {
+		stdin, err = io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := writeStream(writer, typeStdin, stdin); err != nil {
+		return nil, err
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	return readResponse(bufio.NewReader(conn))
+}
+
+func readResponse(reader *bufio.Reader) (*Response, error) {
+	var stdout, stderr bytes.Buffer
+	header := make([]byte, 8)
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return nil, err
+		}
+
+		recordType := header[1]
+		contentLength := binary.BigEndian.Uint16(header[4:6])
+		paddingLength := header[6]
+
+		content := make([]byte, int(contentLength)+int(paddingLength))
+		if _, err := io.ReadFull(reader, content); err != nil {
+			return nil, err
+		}
+		content = content[:contentLength]
+
+		switch recordType {
+		case typeStdout:
+			stdout.Write(content)
+		case typeStderr:
+			stderr.Write(content)
+		case typeEndRequest:
+			return &Response{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}, nil
+		}
+	}
+}
+
+// writeStream writes the given data as a stream of records terminated by an empty record.
+func writeStream(writer io.Writer, recordType byte, data []byte) error {
+	for len(data) > 0 {
+		size := len(data)
+		if size > maxContentSize {
+			size = maxContentSize
+		}
+		if err := writeRecord(writer, recordType, data[:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return writeRecord(writer, recordType, nil)
+}
+
+func writeRecord(writer io.Writer, recordType byte, content []byte) error {
+	if len(content) > maxContentSize {
+		return errors.New("fastcgi record content too large")
+	}
+
+	padding := (8 - len(content)%8) % 8
+	header := []byte{protocolVersion, recordType, 0, requestID, 0, 0, byte(padding), 0}
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(content)))
+
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+	if _, err := writer.Write(content); err != nil {
+		return err
+	}
+	_, err := writer.Write(make([]byte, padding))
+	return err
+}
+
+// encodeParams encodes the given params as FastCGI name-value pairs.
+func encodeParams(params map[string]string) []byte {
+	var buffer bytes.Buffer
+	for name, value := range params {
+		writeLength(&buffer, len(name))
+		writeLength(&buffer, len(value))
+		buffer.WriteString(name)
+		buffer.WriteString(value)
+	}
+	return buffer.Bytes()
+}
+
+func writeLength(buffer *bytes.Buffer, length int) {
+	if length < 128 {
+		buffer.WriteByte(byte(length))
+		return
+	}
+	lengthBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(lengthBytes, uint32(length)|1<<31)
+	buffer.Write(lengthBytes)
+}
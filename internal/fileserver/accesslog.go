@@ -0,0 +1,111 @@
+package fileserver
+
+import (
+	"net/http"
+	"path/filepath"
+
+	"github.com/ssddanbrown/webby/internal/logger"
+)
+
+// defaultAccessLogFile is where, relative to the served folder, access logs are written if no file is given.
+var defaultAccessLogFile = filepath.Join(webbyDir, "access.log")
+
+// AccessLogConfig sets the file, and the Common or Combined format, requests to the server are logged to.
+type AccessLogConfig struct {
+	File   string `json:"file"`
+	Format string `json:"format"`
+}
+
+// SetAccessLog starts writing requests to the given access log, or stops if nil.
+func (fs *FileServer) SetAccessLog(config *AccessLogConfig) error {
+	fs.accessLogMutex.Lock()
+	defer fs.accessLogMutex.Unlock()
+	return fs.setAccessLog(config)
+}
+
+// ToggleAccessLog starts writing requests to the default access log, or stops if already logging.
+func (fs *FileServer) ToggleAccessLog() error {
+	fs.accessLogMutex.Lock()
+	defer fs.accessLogMutex.Unlock()
+
+	fs.mutex.RLock()
+	enabled := fs.AccessLog != nil
+	fs.mutex.RUnlock()
+
+	if enabled {
+		return fs.setAccessLog(nil)
+	}
+	return fs.setAccessLog(&AccessLogConfig{})
+}
+
+// setAccessLog opens the given access log and swaps it in place of any previous log.
+// The access log mutex must be held so changes are made one at a time.
+func (fs *FileServer) setAccessLog(config *AccessLogConfig) error {
+	var accessLog *logger.AccessLog
+	if config != nil {
+		if config.File == "" {
+			config.File = defaultAccessLogFile
+		}
+		if config.Format == "" {
+			config.Format = "combined"
+		}
+
+		fPath := config.File
+		if !filepath.IsAbs(fPath) {
+			fPath = filepath.Join(fs.RootPath, fPath)
+		}
+
+		var err error
+		accessLog, err = logger.OpenAccessLog(fPath, config.Format)
+		if err != nil {
+			return err
+		}
+	}
+
+	fs.mutex.Lock()
+	previous := fs.accessLog
+	fs.AccessLog = config
+	fs.accessLog = accessLog
+	fs.mutex.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+// logAccess writes the given served request to the access log, if enabled.
+func (fs *FileServer) logAccess(r *http.Request, record *RequestRecord) {
+	fs.mutex.RLock()
+	accessLog := fs.accessLog
+	fs.mutex.RUnlock()
+
+	if accessLog == nil {
+		return
+	}
+
+	err := accessLog.Log(logger.AccessEntry{
+		ClientIP:   record.ClientIP,
+		Time:       record.Started,
+		Method:     record.Method,
+		RequestURI: r.URL.RequestURI(),
+		Proto:      record.Proto,
+		Status:     record.Status,
+		Size:       record.Size,
+		Referer:    r.Referer(),
+		UserAgent:  record.UserAgent,
+	})
+	if err != nil {
+		fs.logFor(r).Error("Writing access log", err)
+	}
+}
+
+// logFor provides a logger entry with fields identifying the server and the given request.
+func (fs *FileServer) logFor(r *http.Request) *logger.Entry {
+	fields := logger.Fields{"server": fs.ID}
+	if r != nil {
+		fields["path"] = r.URL.Path
+		fields["client"] = r.RemoteAddr
+	}
+	return logger.With(fields)
+}
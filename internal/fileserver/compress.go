@@ -0,0 +1,203 @@
+package fileserver
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressibleTypes lists the non text/* content types that are worth compressing.
+var compressibleTypes = map[string]bool{
+	"application/javascript": true,
+	"application/json":       true,
+	"application/xml":        true,
+	"application/wasm":       true,
+	"image/svg+xml":          true,
+}
+
+// precompressedExts maps supported encodings to the file extension of their precompressed siblings.
+var precompressedExts = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+// SetCompressionEnabled sets if responses are compressed on the fly, and precompressed siblings served.
+func (fs *FileServer) SetCompressionEnabled(enabled bool) {
+	fs.mutex.Lock()
+	fs.CompressionEnabled = enabled
+	fs.mutex.Unlock()
+}
+
+// ToggleCompressionEnabled switches on-the-fly compression, returning if it is now enabled.
+func (fs *FileServer) ToggleCompressionEnabled() bool {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.CompressionEnabled = !fs.CompressionEnabled
+	return fs.CompressionEnabled
+}
+
+func (fs *FileServer) compressionEnabled() bool {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+	return fs.CompressionEnabled
+}
+
+// isCompressibleType checks if the given content type header value is text based
+// and therefore worth compressing on the fly.
+func isCompressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
+
+// acceptedEncodings parses an Accept-Encoding header into a map of encoding names
+// that the client will accept.
+func acceptedEncodings(header string) map[string]bool {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(strings.TrimSpace(part), ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				quality, _ = strconv.ParseFloat(param[2:], 64)
+			}
+		}
+		accepted[name] = quality > 0
+	}
+	return accepted
+}
+
+// preferredEncoding finds the best encoding, supported by webby, that the
+// given request accepts. An empty string is returned if none are acceptable.
+func preferredEncoding(r *http.Request) string {
+	accepted := acceptedEncodings(r.Header.Get("Accept-Encoding"))
+	for _, encoding := range []string{"br", "gzip"} {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// servePrecompressed attempts to serve a precompressed sibling (file.br or file.gz)
+// of the given file path. Returns true if a sibling was found and served.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, fPath string) bool {
+	encoding := preferredEncoding(r)
+	if encoding == "" || r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(fPath))
+	if contentType == "" {
+		return false
+	}
+
+	compressedPath := fPath + precompressedExts[encoding]
+	stat, err := os.Stat(compressedPath)
+	if err != nil || stat.IsDir() {
+		return false
+	}
+
+	file, err := os.Open(compressedPath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Encoding", encoding)
+	w.Header().Set("Vary", "Accept-Encoding")
+	http.ServeContent(w, r, filepath.Base(fPath), stat.ModTime(), file)
+	return true
+}
+
+// compressor is the common interface of the gzip and brotli writers.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressResponseWriter compresses the response body with the given encoding
+// if the response turns out to be a full response of a compressible type.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding    string
+	writer      compressor
+	wroteHeader bool
+}
+
+func newCompressResponseWriter(w http.ResponseWriter, encoding string) *compressResponseWriter {
+	w.Header().Add("Vary", "Accept-Encoding")
+	return &compressResponseWriter{ResponseWriter: w, encoding: encoding}
+}
+
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	header := cw.Header()
+	if status == http.StatusOK && header.Get("Content-Encoding") == "" && isCompressibleType(header.Get("Content-Type")) {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		if cw.encoding == "br" {
+			cw.writer = brotli.NewWriterLevel(cw.ResponseWriter, 5)
+		} else {
+			cw.writer, _ = gzip.NewWriterLevel(cw.ResponseWriter, gzip.DefaultCompression)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressResponseWriter) Write(data []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.writer != nil {
+		return cw.writer.Write(data)
+	}
+	return cw.ResponseWriter.Write(data)
+}
+
+// Flush sends any buffered compressed data on to the client.
+func (cw *compressResponseWriter) Flush() {
+	if cw.writer != nil {
+		cw.writer.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap provides the underlying response writer for use by http.ResponseController.
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close flushes any remaining compressed data to the underlying writer.
+func (cw *compressResponseWriter) Close() error {
+	if cw.writer != nil {
+		return cw.writer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// ConfigFileName is the name of the optional per-project config file
+// that is read from the root of a served folder.
+const ConfigFileName = "webby.json"
+
+// Config holds the server settings that can be provided by a project's config file.
+type Config struct {
+	ProxyRules     []*ProxyRule        `json:"proxy_rules"`
+	FixtureMode    string              `json:"fixture_mode"`
+	MockAPI        *MockAPIConfig      `json:"mock_api"`
+	Forms          *FormConfig         `json:"forms"`
+	Functions      *FunctionsConfig    `json:"functions"`
+	Scripts        []*ScriptHandler    `json:"scripts"`
+	Realtime       []*RealtimeEndpoint `json:"realtime"`
+	Transpile      *TranspileConfig    `json:"transpile"`
+	ModernImages   bool                `json:"modern_images"`
+	Throttle       *ThrottleConfig     `json:"throttle"`
+	Faults         []*FaultRule        `json:"faults"`
+	FaultsDisabled bool                `json:"faults_disabled"`
+	AccessLog      *AccessLogConfig    `json:"access_log"`
+	IdleTimeout    *int                `json:"idle_timeout_mins"`
+}
+
+// loadConfig reads the config file from the given root path.
+// A nil config is returned if the folder has no config file.
+func loadConfig(rootPath string) (*Config, error) {
+	data, err := os.ReadFile(filepath.Join(rootPath, ConfigFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := new(Config)
+	err = json.Unmarshal(data, config)
+	return config, err
+}
+
+// applyConfig loads the project config file, if existing, and applies its settings to the server.
+func (fs *FileServer) applyConfig() error {
+	config, err := loadConfig(fs.RootPath)
+	if err != nil || config == nil {
+		return err
+	}
+
+	for _, rule := range config.ProxyRules {
+		if err := fs.AddProxyRule(rule); err != nil {
+			return err
+		}
+	}
+
+	if err := fs.SetFixtureMode(config.FixtureMode); err != nil {
+		return err
+	}
+
+	if config.MockAPI != nil {
+		if err := fs.SetMockAPI(config.MockAPI); err != nil {
+			return err
+		}
+	}
+
+	if config.Forms != nil {
+		if err := fs.SetForms(config.Forms); err != nil {
+			return err
+		}
+	}
+
+	if config.Functions != nil {
+		if err := fs.SetFunctions(config.Functions); err != nil {
+			return err
+		}
+	}
+
+	for _, handler := range config.Scripts {
+		if err := fs.AddScriptHandler(handler); err != nil {
+			return err
+		}
+	}
+
+	for _, endpoint := range config.Realtime {
+		if err := fs.AddRealtimeEndpoint(endpoint); err != nil {
+			return err
+		}
+	}
+
+	for _, rule := range config.Faults {
+		if err := fs.AddFaultRule(rule); err != nil {
+			return err
+		}
+	}
+	fs.SetFaultsEnabled(len(config.Faults) > 0 && !config.FaultsDisabled)
+
+	if config.AccessLog != nil {
+		if err := fs.SetAccessLog(config.AccessLog); err != nil {
+			return err
+		}
+	}
+
+	if config.ModernImages {
+		fs.SetModernImages(true)
+	}
+
+	if config.Throttle != nil {
+		if err := fs.SetThrottle(config.Throttle); err != nil {
+			return err
+		}
+	}
+
+	if config.Transpile != nil {
+		if err := fs.SetTranspile(config.Transpile); err != nil {
+			return err
+		}
+	}
+
+	if config.IdleTimeout != nil {
+		fs.SetIdleTimeout(*config.IdleTimeout)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package fileserver
+
+import (
+	"time"
+)
+
+// internalWriteWindow is how long after webby writes a file that change
+// events for that file are treated as caused by webby itself.
+const internalWriteWindow = time.Second
+
+// EventHandler receives named events, with detail data, to be sent on to pages open on a server.
+type EventHandler func(event string, detail interface{})
+
+// SetEventHandler sets the handler used to send events on to open pages.
+func (fs *FileServer) SetEventHandler(handler EventHandler) {
+	fs.mutex.Lock()
+	fs.eventHandler = handler
+	fs.mutex.Unlock()
+}
+
+// IsInternalWrite checks if the given file path was recently written by this server,
+// or is its access log, in which case the change should not cause open pages to reload.
+func (fs *FileServer) IsInternalWrite(path string) bool {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	if fs.accessLog != nil && fs.accessLog.Path() == path {
+		return true
+	}
+	writeTime, exists := fs.internalWrites[path]
+	return exists && time.Since(writeTime) < internalWriteWindow
+}
+
+func (fs *FileServer) markInternalWrite(path string) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if fs.internalWrites == nil {
+		fs.internalWrites = make(map[string]time.Time)
+	}
+	for existingPath, writeTime := range fs.internalWrites {
+		if time.Since(writeTime) > internalWriteWindow {
+			delete(fs.internalWrites, existingPath)
+		}
+	}
+	fs.internalWrites[path] = time.Now()
+}
+
+func (fs *FileServer) sendEvent(event string, detail interface{}) {
+	fs.mutex.RLock()
+	handler := fs.eventHandler
+	fs.mutex.RUnlock()
+
+	if handler != nil {
+		handler(event, detail)
+	}
+}
+
+// DependencyHandler is notified of files that pages have been rendered from, such as
+// included partials and layouts, so changes to them can be watched.
+type DependencyHandler func(path string)
+
+// SetDependencyHandler sets the handler notified of new page dependencies.
+func (fs *FileServer) SetDependencyHandler(handler DependencyHandler) {
+	fs.mutex.Lock()
+	fs.dependencyHandler = handler
+	fs.mutex.Unlock()
+}
+
+// recordDependencies stores the files the given page was rendered from,
+// replacing those recorded for any previous render of the page.
+func (fs *FileServer) recordDependencies(page string, dependencies map[string]bool) {
+	fs.mutex.Lock()
+	if fs.dependencies == nil {
+		fs.dependencies = make(map[string]map[string]bool)
+	}
+	for _, pages := range fs.dependencies {
+		delete(pages, page)
+	}
+
+	var added []string
+	for dependency := range dependencies {
+		if fs.dependencies[dependency] == nil {
+			fs.dependencies[dependency] = make(map[string]bool)
+			added = append(added, dependency)
+		}
+		fs.dependencies[dependency][page] = true
+	}
+	handler := fs.dependencyHandler
+	fs.mutex.Unlock()
+
+	if handler != nil {
+		for _, dependency := range added {
+			handler(dependency)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package fileserver
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ssddanbrown/webby/internal/logger"
+)
+
+// defaultFaultStall is how long requests are held for by stall faults without a set duration.
+const defaultFaultStall = 30 * time.Second
+
+// faultTypes lists the kinds of fault that can be injected into matching requests.
+var faultTypes = map[string]bool{
+	"status":   true,
+	"truncate": true,
+	"drop":     true,
+	"stall":    true,
+}
+
+// FaultRule fails a percentage of requests matching a path glob, either randomly
+// or, when a seed is given, in the same sequence each time the server starts.
+type FaultRule struct {
+	Path    string  `json:"path"`
+	Type    string  `json:"type"`
+	Status  int     `json:"status,omitempty"`
+	Stall   int     `json:"stall_ms,omitempty"`
+	Percent float64 `json:"percent"`
+	Seed    int64   `json:"seed,omitempty"`
+	random  *rand.Rand
+	mutex   sync.Mutex
+}
+
+// AddFaultRule validates and adds the given fault rule to the server.
+func (fs *FileServer) AddFaultRule(rule *FaultRule) error {
+	if rule.Path == "" {
+		rule.Path = "/"
+	}
+	if _, err := path.Match(rule.Path, ""); err != nil {
+		return fmt.Errorf("invalid fault path glob %q", rule.Path)
+	}
+	if !faultTypes[rule.Type] {
+		return fmt.Errorf("unknown fault type %q, expected status, truncate, drop or stall", rule.Type)
+	}
+	if rule.Type == "status" && rule.Status == 0 {
+		rule.Status = http.StatusInternalServerError
+	}
+	if rule.Status != 0 && (rule.Status < 200 || rule.Status > 599) {
+		return fmt.Errorf("fault status %d must be between 200 and 599", rule.Status)
+	}
+	if rule.Percent <= 0 || rule.Percent > 100 {
+		return fmt.Errorf("fault percentage %v must be above 0 and no more than 100", rule.Percent)
+	}
+
+	seed := rule.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rule.random = rand.New(rand.NewSource(seed))
+
+	fs.mutex.Lock()
+	fs.FaultRules = append(fs.FaultRules, rule)
+	fs.mutex.Unlock()
+	return nil
+}
+
+// RemoveFaultRule removes the fault rule at the given index from the server.
+func (fs *FileServer) RemoveFaultRule(index int) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if index < 0 || index >= len(fs.FaultRules) {
+		return fmt.Errorf("fault rule %d not found", index)
+	}
+	fs.FaultRules = append(fs.FaultRules[:index], fs.FaultRules[index+1:]...)
+	return nil
+}
+
+// SetFaultsEnabled switches fault injection on or off without removing the fault rules.
+func (fs *FileServer) SetFaultsEnabled(enabled bool) {
+	fs.mutex.Lock()
+	fs.FaultsEnabled = enabled
+	fs.mutex.Unlock()
+}
+
+// ToggleFaultsEnabled switches fault injection, returning if it is now enabled.
+func (fs *FileServer) ToggleFaultsEnabled() bool {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.FaultsEnabled = !fs.FaultsEnabled
+	return fs.FaultsEnabled
+}
+
+// matchFault finds the first fault rule matching the given path that chooses to fail this request.
+func (fs *FileServer) matchFault(requestPath string) *FaultRule {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	if !fs.FaultsEnabled {
+		return nil
+	}
+	for _, rule := range fs.FaultRules {
+		if matchPathGlob(rule.Path, requestPath) && rule.triggers() {
+			return rule
+		}
+	}
+	return nil
+}
+
+// triggers decides, using the rule's percentage, if the current request should fail.
+func (rule *FaultRule) triggers() bool {
+	rule.mutex.Lock()
+	defer rule.mutex.Unlock()
+	return rule.random.Float64()*100 < rule.Percent
+}
+
+// Describe provides a short summary of the fault for display in the manager.
+func (rule *FaultRule) Describe() string {
+	switch rule.Type {
+	case "status":
+		return "Responding " + strconv.Itoa(rule.Status)
+	case "truncate":
+		return "Truncating responses"
+	case "drop":
+		return "Dropping connections"
+	}
+	return "Stalling for " + rule.stallDuration().String()
+}
+
+func (rule *FaultRule) stallDuration() time.Duration {
+	if rule.Stall > 0 {
+		return time.Duration(rule.Stall) * time.Millisecond
+	}
+	return defaultFaultStall
+}
+
+// injectFault applies the given fault to the request. It returns a response writer to
+// continue serving the request with, or nil if the fault has ended the request.
+func injectFault(w http.ResponseWriter, r *http.Request, rule *FaultRule) *truncateResponseWriter {
+	logger.Devlog("Injecting " + rule.Type + " fault into " + r.URL.Path)
+
+	switch rule.Type {
+	case "status":
+		http.Error(w, "Webby injected fault: "+http.StatusText(rule.Status), rule.Status)
+	case "drop":
+		// Aborting the handler closes the connection without sending a response
+		panic(http.ErrAbortHandler)
+	case "stall":
+		select {
+		case <-time.After(rule.stallDuration()):
+			http.Error(w, "Webby injected fault: stalled request", http.StatusGatewayTimeout)
+		case <-r.Context().Done():
+		}
+	case "truncate":
+		return &truncateResponseWriter{ResponseWriter: w, buffer: new(bytes.Buffer)}
+	}
+	return nil
+}
+
+// truncateResponseWriter buffers a response so that only half of its body
+// is sent, followed by the connection being cut.
+type truncateResponseWriter struct {
+	http.ResponseWriter
+	status int
+	buffer *bytes.Buffer
+}
+
+func (tw *truncateResponseWriter) WriteHeader(status int) {
+	if tw.status == 0 {
+		tw.status = status
+	}
+}
+
+func (tw *truncateResponseWriter) Write(data []byte) (int, error) {
+	if tw.status == 0 {
+		tw.WriteHeader(http.StatusOK)
+	}
+	return tw.buffer.Write(data)
+}
+
+// Close sends the first half of the buffered response, while announcing its
+// full length, then aborts the connection so the client sees a cut off body.
+func (tw *truncateResponseWriter) Close() {
+	body := tw.buffer.Bytes()
+	tw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	tw.ResponseWriter.WriteHeader(tw.status)
+	tw.ResponseWriter.Write(body[:len(body)/2])
+	http.NewResponseController(tw.ResponseWriter).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+// Unwrap provides the underlying response writer for use by http.ResponseController.
+func (tw *truncateResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
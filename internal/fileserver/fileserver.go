@@ -4,33 +4,137 @@ import (
 	"fmt"
 	"github.com/ssddanbrown/webby/internal/logger"
 	"github.com/ssddanbrown/webby/internal/util"
-	"io"
 	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+// webbyDir is the folder, relative to the server root, where project data
+// such as fixtures and form submissions are stored. It is never served.
+const webbyDir = ".webby"
+
 type FileServer struct {
-	ID         int    `json:"id"`
-	Port       int    `json:"port"`
-	RootPath   string `json:"path"`
-	OpenedFile string `json:"file"`
-	server     net.Listener
-	options    *util.Options
+	ServerSettings
+	server            net.Listener
+	options           *util.Options
+	proxy             *httputil.ReverseProxy
+	mutex             sync.RWMutex
+	mockMutex         sync.Mutex
+	accessLogMutex    sync.Mutex
+	eventHandler      EventHandler
+	internalWrites    map[string]time.Time
+	dependencyHandler DependencyHandler
+	dependencies      map[string]map[string]bool
+	minifyStats       map[string]*MinifyStat
+	minifyCache       map[string]*minifiedOutput
+	scriptCache       scriptCache
+	requests          requestLog
+	accessLog         *logger.AccessLog
+	stats             serverStats
+}
+
+// ServerSettings are the settings of a server. Those that can change while the server
+// runs are guarded by the server mutex, so should be read from a Snapshot elsewhere.
+type ServerSettings struct {
+	ID                 int                 `json:"id"`
+	Port               int                 `json:"port"`
+	RootPath           string              `json:"path"`
+	OpenedFile         string              `json:"file"`
+	CompressionEnabled bool                `json:"compression"`
+	HTTPS              bool                `json:"https"`
+	ProxyURL           string              `json:"proxy,omitempty"`
+	ProxyRules         []*ProxyRule        `json:"proxy_rules"`
+	FixtureMode        string              `json:"fixture_mode"`
+	MockAPI            *MockAPIConfig      `json:"mock_api,omitempty"`
+	Forms              *FormConfig         `json:"forms,omitempty"`
+	Functions          *FunctionsConfig    `json:"functions,omitempty"`
+	ScriptHandlers     []*ScriptHandler    `json:"script_handlers"`
+	Realtime           []*RealtimeEndpoint `json:"realtime"`
+	Transpile          *TranspileConfig    `json:"transpile,omitempty"`
+	ProductionPreview  bool                `json:"production_preview"`
+	ModernImages       bool                `json:"modern_images"`
+	Throttle           *ThrottleConfig     `json:"throttle,omitempty"`
+	FaultRules         []*FaultRule        `json:"fault_rules"`
+	FaultsEnabled      bool                `json:"faults_enabled"`
+	AccessLog          *AccessLogConfig    `json:"access_log,omitempty"`
+	IdleTimeout        int                 `json:"idle_timeout_mins,omitempty"`
 }
 
+// serversMutex guards the ports and IDs given out to servers, which may be started concurrently.
+var serversMutex sync.Mutex
 var usedPorts = make(map[int]bool)
 var idCounter int
 
 // StartFileServer starts a new file server and returns the instance
 func StartFileServer(path string, options *util.Options) (*FileServer, error) {
+	return StartFileServerOnPort(path, 0, options)
+}
+
+// StartFileServerOnPort starts a new file server on the given port, or a free port if zero
+func StartFileServerOnPort(path string, port int, options *util.Options) (*FileServer, error) {
+	fServer, err := newFileServer(path, port, options)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fServer.applyConfig()
+	if err != nil {
+		logger.With(logger.Fields{"server": fServer.ID, "path": fServer.RootPath}).Error("Loading "+ConfigFileName, err)
+	}
+
+	go fServer.listenAndServe()
+
+	return fServer, nil
+}
 
+// StartProxyServer starts a new server that proxies all requests to the given
+// upstream URL while using the given path as the folder to watch for changes.
+func StartProxyServer(path string, upstream string, options *util.Options) (*FileServer, error) {
+	return StartProxyServerOnPort(path, upstream, 0, options)
+}
+
+// StartProxyServerOnPort starts a new proxy server on the given port, or a free port if zero
+func StartProxyServerOnPort(path string, upstream string, port int, options *util.Options) (*FileServer, error) {
+	upstreamURL, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if upstreamURL.Scheme == "" || upstreamURL.Host == "" {
+		return nil, fmt.Errorf("proxy upstream %q must be an absolute URL", upstream)
+	}
+
+	fServer, err := newFileServer(path, port, options)
+	if err != nil {
+		return nil, err
+	}
+
+	fServer.ProxyURL = upstreamURL.String()
+	fServer.OpenedFile = ""
+	fServer.proxy = fServer.newReverseProxy(upstreamURL)
+
+	err = fServer.applyConfig()
+	if err != nil {
+		logger.With(logger.Fields{"server": fServer.ID, "path": fServer.RootPath}).Error("Loading "+ConfigFileName, err)
+	}
+
+	go fServer.listenAndServe()
+
+	return fServer, nil
+}
+
+func newFileServer(path string, port int, options *util.Options) (*FileServer, error) {
 	rootPath := util.FormatRootPath(path)
-	port := getFreePort()
 	file := ""
 
-	if util.IsHTMLFile(path) {
+	if util.IsHTMLFile(path) || util.IsMarkdownFile(path) {
 		file = filepath.Base(path)
 	}
 
@@ -39,80 +143,287 @@ func StartFileServer(path string, options *util.Options) (*FileServer, error) {
 		return nil, err
 	}
 
-	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	port, err = reservePort(port)
 	if err != nil {
 		return nil, err
 	}
 
-	go listenAndServe(options, listener, rootPath, serverRootPath)
+	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		return nil, err
+	}
 
+	serversMutex.Lock()
 	idCounter++
+	id := idCounter
+	serversMutex.Unlock()
 
-	return &FileServer{
-		ID:         idCounter,
-		Port:       port,
-		RootPath:   serverRootPath,
-		OpenedFile: file,
-		server:     listener,
-		options:    options,
-	}, nil
+	fServer := &FileServer{
+		ServerSettings: ServerSettings{
+			ID:                 id,
+			Port:               port,
+			RootPath:           serverRootPath,
+			OpenedFile:         file,
+			CompressionEnabled: true,
+			HTTPS:              options.TLSConfig != nil,
+			IdleTimeout:        options.IdleTimeout,
+		},
+		server:  listener,
+		options: options,
+	}
+	fServer.stats.lastActivity = time.Now()
+	return fServer, nil
+}
+
+// Snapshot provides a copy of the current settings of the server, which is safe
+// to read, or encode, while the server keeps handling requests.
+func (fs *FileServer) Snapshot() ServerSettings {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	settings := fs.ServerSettings
+	settings.ProxyRules = append([]*ProxyRule(nil), settings.ProxyRules...)
+	settings.ScriptHandlers = append([]*ScriptHandler(nil), settings.ScriptHandlers...)
+	settings.Realtime = append([]*RealtimeEndpoint(nil), settings.Realtime...)
+	settings.FaultRules = append([]*FaultRule(nil), settings.FaultRules...)
+	return settings
 }
 
 // Url provides the direct URL for the root of the started server
-func (fs *FileServer) Url() string {
-	return fmt.Sprintf("http://localhost:%d", fs.Port)
+func (settings *ServerSettings) Url() string {
+	return fmt.Sprintf("%s://localhost:%d", settings.Scheme(), settings.Port)
+}
+
+// Scheme provides the URL scheme the server is being served over
+func (settings *ServerSettings) Scheme() string {
+	if settings.HTTPS {
+		return "https"
+	}
+	return "http"
 }
 
 // Destroy the file server and take it offline
 func (fs *FileServer) Destroy() {
 	err := fs.server.Close()
 	if err != nil {
-		logger.Error("File server destroy", err)
+		fs.logFor(nil).Error("File server destroy", err)
+	}
+
+	fs.mutex.RLock()
+	for _, endpoint := range fs.Realtime {
+		endpoint.closeSockets()
 	}
+	fs.mutex.RUnlock()
+
+	fs.SetAccessLog(nil)
 }
 
-func listenAndServe(options *util.Options, listener net.Listener, rootPath string, serverRootPath string) {
+func (fs *FileServer) listenAndServe() {
 	handler := http.NewServeMux()
-	staticHandler := http.FileServer(http.Dir(rootPath))
-
-	handler.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		rPath := r.URL.Path
-		fPath := filepath.Join(serverRootPath, rPath)
-		logger.Devlog(fPath)
-
-		// Check if an index html file is being served and update request path if so
-		if rPath == "/" {
-			indexFilePath := filepath.Join(serverRootPath, rPath, "index.html")
-			_, err := os.Stat(indexFilePath)
-			if err == nil {
-				fPath = indexFilePath
-			}
+	handler.HandleFunc("/", fs.handleRequest)
+
+	server := &http.Server{Handler: handler}
+	if fs.HTTPS {
+		server.TLSConfig = fs.options.TLSConfig.Clone()
+		server.ServeTLS(fs.server, "", "")
+		return
+	}
+	server.Serve(fs.server)
+}
+
+func (fs *FileServer) handleRequest(w http.ResponseWriter, r *http.Request) {
+	// Record every request, and its response, for the manager's request inspector
+	iw := &inspectResponseWriter{ResponseWriter: w}
+	fs.startRequest()
+	defer fs.recordRequest(r, iw, time.Now())
+	w = iw
+
+	// Serve mock realtime endpoints before wrapping the writer so sockets can be hijacked
+	if endpoint := fs.matchRealtimeEndpoint(r.URL.Path); endpoint != nil {
+		fs.logFor(r).Debug("Serving " + endpoint.Type + " endpoint " + endpoint.Path)
+		fs.serveRealtime(w, r, endpoint)
+		return
+	}
+
+	// Fail requests matching a fault rule to test error handling and retries
+	if rule := fs.matchFault(r.URL.Path); rule != nil {
+		tw := injectFault(w, r, rule)
+		if tw == nil {
+			return
+		}
+		defer tw.Close()
+		w = tw
+	}
+
+	// Simulate slow network conditions on the bytes sent over the wire
+	if throttle := fs.matchThrottle(r.URL.Path); throttle != nil {
+		w = newThrottleResponseWriter(w, r, throttle)
+	}
+
+	// Compress text responses on the fly
+	if fs.compressionEnabled() {
+		if encoding := preferredEncoding(r); encoding != "" {
+			cw := newCompressResponseWriter(w, encoding)
+			defer cw.Close()
+			w = cw
 		}
+	}
+
+	// Minify responses when previewing the site as it would be in production
+	if fs.productionPreview() {
+		mw := fs.newMinifyResponseWriter(w, r.URL.Path)
+		defer mw.Close()
+		w = mw
+	}
+
+	// Serve the built-in developer utilities from their reserved path on every server
+	if isUtilityPath(r.URL.Path) {
+		fs.serveUtility(w, r)
+		return
+	}
+
+	// Forward requests matching a proxy rule before any file lookup
+	if rule := fs.matchProxyRule(r.URL.Path); rule != nil {
+		fs.logFor(r).Debug("Proxying to " + rule.Upstream + r.URL.RequestURI())
+		fs.serveWithFixtures(w, r, rule.proxy, false)
+		return
+	}
+
+	if functionPath, ok := fs.matchFunction(r.URL.Path); ok {
+		fs.logFor(r).Debug("Running function " + functionPath)
+		fs.serveFunction(w, r, functionPath)
+		return
+	}
+
+	if mockAPI := fs.matchMockAPI(r.URL.Path); mockAPI != nil {
+		fs.serveMockAPI(w, r, mockAPI)
+		return
+	}
+
+	if forms := fs.matchFormCapture(r); forms != nil {
+		fs.serveFormCapture(w, r, forms)
+		return
+	}
+
+	if fs.proxy != nil {
+		fs.logFor(r).Debug("Proxying to " + fs.ProxyURL + r.URL.RequestURI())
+		fs.serveWithFixtures(w, r, fs.proxy, true)
+		return
+	}
+
+	fs.serveStatic(w, r)
+}
 
-		// Prevent caching of served files
-		w.Header().Add("Cache-Control", "no-cache")
-
-		// Inject livereload script if serving a HTML file
-		if util.IsHTMLFile(fPath) && options.LiveReloadEnabled {
-			_, err := os.Stat(fPath)
-			if err == nil {
-				file, err := os.Open(fPath)
-				if err == nil {
-					w.Header().Add("Content-Type", "text/html")
-					io.Copy(w, file)
-					fmt.Fprintf(w, "\n<script src=\"http://localhost:%d/livereload.js\"></script>\n", options.ManagerPort)
-					return
-				}
-			}
+func (fs *FileServer) serveStatic(w http.ResponseWriter, r *http.Request) {
+	rPath := r.URL.Path
+	fPath := filepath.Join(fs.RootPath, rPath)
+	fs.logFor(r).Debug("Serving " + fPath)
+
+	// Keep stored project data, which may hold captured requests and responses, and function sources private
+	if isWebbyPath(rPath) || fs.isFunctionSource(fPath) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Check if an index html file is being served and update request path if so
+	if rPath == "/" {
+		indexFilePath := filepath.Join(fs.RootPath, rPath, "index.html")
+		_, err := os.Stat(indexFilePath)
+		if err == nil {
+			fPath = indexFilePath
 		}
+	}
 
-		// Otherwise serve a static file
-		staticHandler.ServeHTTP(w, r)
-	})
+	// Prevent caching of served files, unless previewing production caching
+	w.Header().Add("Cache-Control", fs.cacheControl())
 
-	http.Serve(listener, handler)
+	// Run files mapped to a CGI or FastCGI script handler
+	if handler, scriptPath := fs.matchScript(fPath); handler != nil {
+		fs.logFor(r).Debug("Running script " + scriptPath)
+		fs.serveScript(w, r, handler, scriptPath)
+		return
+	}
+
+	// Render HTML pages to process includes, layouts and inject the livereload script
+	if util.IsHTMLFile(fPath) {
+		if stat, err := os.Stat(fPath); err == nil && !stat.IsDir() {
+			fs.servePage(w, r, fPath)
+			return
+		}
+	}
+
+	// Transpile TypeScript and JSX files, or bundle configured entry points, to JavaScript
+	if transpile, bundle := fs.matchTranspile(fPath); transpile {
+		if stat, err := os.Stat(fPath); err == nil && !stat.IsDir() {
+			fs.serveTranspiled(w, r, fPath, bundle)
+			return
+		}
+	}
+
+	// Serve resized image variants, or modern format siblings, of images
+	if isResizableImage(fPath) {
+		variant, err := parseImageVariant(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if variant != nil {
+			fs.serveImageVariant(w, r, fPath, variant)
+			return
+		}
+		if fs.modernImages() && fs.serveModernImage(w, r, fPath) {
+			return
+		}
+	}
+
+	// Render markdown files as HTML pages
+	if util.IsMarkdownFile(fPath) {
+		if stat, err := os.Stat(fPath); err == nil && !stat.IsDir() {
+			fs.serveMarkdown(w, r, fPath)
+			return
+		}
+	}
+
+	// Serve precompressed siblings where available
+	if fs.compressionEnabled() && servePrecompressed(w, r, fPath) {
+		return
+	}
+
+	// Otherwise serve a static file
+	setMinifySource(w, fPath)
+	http.FileServer(http.Dir(fs.RootPath)).ServeHTTP(w, r)
 }
 
+// liveReloadScriptTag provides the script tag to load the livereload script from the
+// manager, using the host the request was made to so it works across the network.
+func (fs *FileServer) liveReloadScriptTag(requestHost string) string {
+	host, _, err := net.SplitHostPort(requestHost)
+	if err != nil {
+		host = "localhost"
+	}
+	managerURL := fs.Scheme() + "://" + net.JoinHostPort(host, strconv.Itoa(fs.options.ManagerPort))
+	return fmt.Sprintf("\n<script src=\"%s/webby-events.js\"></script>\n<script src=\"%s/livereload.js\"></script>\n", managerURL, managerURL)
+}
+
+// reservePort marks the given port, or a free port if zero, as used by a server.
+func reservePort(port int) (int, error) {
+	serversMutex.Lock()
+	defer serversMutex.Unlock()
+
+	if port == 0 {
+		return getFreePort(), nil
+	}
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %d must be between 1 and 65535", port)
+	}
+	if !isPortFree(port) {
+		return 0, fmt.Errorf("port %d is already in use", port)
+	}
+	usedPorts[port] = true
+	return port, nil
+}
+
+// getFreePort finds and marks a free port for a server. The servers mutex must be held.
 func getFreePort() int {
 	portMin := 8000
 	portMax := 9000
@@ -134,3 +445,9 @@ func isPortFree(port int) bool {
 
 	return util.IsPortFree(port)
 }
+
+// isWebbyPath checks if the given request path is within the folder of stored project data.
+func isWebbyPath(rPath string) bool {
+	first := strings.SplitN(strings.TrimPrefix(path.Clean("/"+rPath), "/"), "/", 2)[0]
+	return strings.EqualFold(first, webbyDir)
+}
@@ -0,0 +1,1177 @@
+package fileserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ssddanbrown/webby/internal/util"
+	"golang.org/x/net/websocket"
+)
+
+func getTestFileServer(t *testing.T) (*FileServer, string) {
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	fServer, err := StartFileServer(tempDir, &util.Options{LiveReloadEnabled: true, ManagerPort: 35729})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return fServer, tempDir
+}
+
+func getWithEncoding(t *testing.T, url string, encoding string) *http.Response {
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Accept-Encoding", encoding)
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return resp
+}
+
+func TestHTMLCompression(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	ioutil.WriteFile(filepath.Join(tempDir, "index.html"), []byte("<html><body><p>Hello</p></body></html>"), 0644)
+
+	resp := getWithEncoding(t, fServer.Url()+"/index.html", "gzip, deflate")
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected gzip encoding, got %q", resp.Header.Get("Content-Encoding"))
+	}
+
+	reader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body, _ := ioutil.ReadAll(reader)
+	if !strings.HasSuffix(string(body), "livereload.js\"></script>\n</body></html>") {
+		t.Errorf("Compressed HTML did not contain the livereload script before the closing body tag, got %q", string(body))
+	}
+
+	fServer.SetCompressionEnabled(false)
+	resp = getWithEncoding(t, fServer.Url()+"/index.html", "gzip")
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "" {
+		t.Error("Response was compressed with compression disabled")
+	}
+}
+
+func TestPrecompressedServing(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	ioutil.WriteFile(filepath.Join(tempDir, "app.js"), []byte("console.log('plain')"), 0644)
+	ioutil.WriteFile(filepath.Join(tempDir, "app.js.br"), []byte("brotli-data"), 0644)
+
+	resp := getWithEncoding(t, fServer.Url()+"/app.js", "gzip, br")
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.Header.Get("Content-Encoding") != "br" || string(body) != "brotli-data" {
+		t.Error("Precompressed brotli sibling was not served")
+	}
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/javascript") && !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/javascript") {
+		t.Errorf("Unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	if resp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Error("Vary header was not set")
+	}
+}
+
+func TestProxyInjectsLiveReload(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Domain: "127.0.0.1"})
+			http.Redirect(w, r, "http://"+r.Host+"/dashboard", http.StatusFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte("<html><body><p>Upstream</p></body></html>"))
+		gz.Close()
+	}))
+	defer upstream.Close()
+
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+	fServer, err := StartProxyServer(tempDir, upstream.URL, &util.Options{LiveReloadEnabled: true, ManagerPort: 35729})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer fServer.Destroy()
+
+	resp := getWithEncoding(t, fServer.Url()+"/", "identity")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "livereload.js\"></script>\n</body>") {
+		t.Errorf("Livereload script was not injected before the closing body tag: %s", body)
+	}
+
+	resp = getWithEncoding(t, fServer.Url()+"/login", "")
+	resp.Body.Close()
+	if resp.Header.Get("Location") != fServer.Url()+"/dashboard" {
+		t.Errorf("Redirect location was not rewritten, got %q", resp.Header.Get("Location"))
+	}
+	if strings.Contains(strings.ToLower(resp.Header.Get("Set-Cookie")), "domain=") {
+		t.Error("Cookie domain was not removed")
+	}
+}
+
+func TestProxyRules(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path + " " + r.Header.Get("X-Api-Key")))
+	}))
+	defer upstream.Close()
+
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+	config := `{"proxy_rules": [{"prefix": "/api", "upstream": "` + upstream.URL + `", "rewrite": "/v1", "headers": {"X-Api-Key": "secret"}}]}`
+	ioutil.WriteFile(filepath.Join(tempDir, ConfigFileName), []byte(config), 0644)
+	ioutil.WriteFile(filepath.Join(tempDir, "apidocs.html"), []byte("docs"), 0644)
+
+	fServer, err := StartFileServer(tempDir, &util.Options{ManagerPort: 35729})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer fServer.Destroy()
+
+	resp := getWithEncoding(t, fServer.Url()+"/api/users", "")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "/v1/users secret" {
+		t.Errorf("Proxy rule did not rewrite the request as expected, got %q", body)
+	}
+
+	resp = getWithEncoding(t, fServer.Url()+"/apidocs.html", "")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "docs" {
+		t.Error("Proxy rule matched a path outside of its prefix")
+	}
+}
+
+func TestFixtureRecordAndReplay(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"path": "` + r.URL.Path + `"}`))
+	}))
+
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+	fServer, err := StartFileServer(tempDir, &util.Options{ManagerPort: 35729})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer fServer.Destroy()
+	fServer.AddProxyRule(&ProxyRule{Prefix: "/api", Upstream: upstream.URL})
+
+	fServer.SetFixtureMode(FixtureModeRecord)
+	resp := getWithEncoding(t, fServer.Url()+"/api/users?page=2", "gzip")
+	resp.Body.Close()
+
+	fixtures, _ := fServer.ListFixtures()
+	if len(fixtures) != 1 || fixtures[0].Query != "page=2" {
+		t.Fatal("Proxied response was not recorded as a fixture")
+	}
+
+	upstream.Close()
+	fServer.SetFixtureMode(FixtureModeReplay)
+	resp = getWithEncoding(t, fServer.Url()+"/api/users?page=2", "")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != `{"path": "/api/users"}` || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Fixture was not replayed, got %q", body)
+	}
+
+	resp = getWithEncoding(t, fServer.Url()+"/api/users?page=3", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Error("Replay mode did not return a 404 for a request without a fixture")
+	}
+}
+
+func TestProxyFixtures(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><p>Upstream</p></body></html>"))
+	}))
+	defer upstream.Close()
+
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+	fServer, err := StartProxyServer(tempDir, upstream.URL, &util.Options{LiveReloadEnabled: true, ManagerPort: 35729})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer fServer.Destroy()
+
+	getPage := func() (int, string) {
+		resp := getWithEncoding(t, fServer.Url()+"/page", "")
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, string(body)
+	}
+
+	fServer.SetFixtureMode(FixtureModeRecord)
+	if _, body := getPage(); strings.Count(body, "livereload.js") != 1 {
+		t.Errorf("Expected live reload to be injected once while recording, got %q", body)
+	}
+	fixtures, _ := fServer.ListFixtures()
+	if len(fixtures) != 1 || strings.Contains(fixtures[0].Body, "livereload.js") {
+		t.Fatalf("Expected the upstream response to be recorded without live reload, got %+v", fixtures)
+	}
+
+	fServer.SetFixtureMode(FixtureModeReplay)
+	if _, body := getPage(); strings.Count(body, "livereload.js") != 1 || !strings.Contains(body, "Upstream") {
+		t.Errorf("Expected live reload to be injected once on replay, got %q", body)
+	}
+
+	fPath, _ := fServer.fixtureFilePath(fixtures[0].File)
+	ioutil.WriteFile(fPath, []byte(`{"status": 200, "body": "edited`), 0644)
+	fServer.SetFixtureMode(FixtureModeRecordMissing)
+	if status, body := getPage(); status != http.StatusInternalServerError || !strings.Contains(body, fixtures[0].File) {
+		t.Errorf("Expected an unreadable fixture to be reported, got %d %q", status, body)
+	}
+	if data, _ := ioutil.ReadFile(fPath); string(data) != `{"status": 200, "body": "edited` {
+		t.Error("Expected an unreadable fixture not to be recorded over")
+	}
+}
+
+func TestMockAPI(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	db := `{
+		"posts": [{"id": 1, "title": "Beta", "views": 5}, {"id": 2, "title": "Alpha", "views": 50}, {"id": 3, "title": "Gamma", "views": 20}],
+		"comments": [{"id": 1, "postId": 2, "body": "Nice"}],
+		"profile": {"name": "webby"}
+	}`
+	ioutil.WriteFile(filepath.Join(tempDir, "db.json"), []byte(db), 0644)
+	if err := fServer.SetMockAPI(&MockAPIConfig{Prefix: "/api", File: "./WEBBY.json"}); err == nil {
+		t.Error("Expected the project config file to be rejected as a mock API file")
+	}
+	err := fServer.SetMockAPI(&MockAPIConfig{Prefix: "/api"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var events []string
+	fServer.SetEventHandler(func(event string, detail interface{}) {
+		events = append(events, event)
+	})
+
+	resp := getWithEncoding(t, fServer.Url()+"/api/posts?views_gte=10&_sort=title&_page=1&_limit=1", "")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("X-Total-Count") != "2" || !strings.Contains(string(body), "Alpha") || strings.Contains(string(body), "Gamma") {
+		t.Errorf("Unexpected filtered list response: %s", body)
+	}
+
+	resp, err = http.Post(fServer.Url()+"/api/posts/2/comments", "application/json", strings.NewReader(`{"body": "Great"}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || !strings.Contains(string(body), `"postId": 2`) || !strings.Contains(string(body), `"id": 2`) {
+		t.Errorf("Unexpected nested create response: %s", body)
+	}
+
+	saved, _ := ioutil.ReadFile(filepath.Join(tempDir, "db.json"))
+	if !strings.Contains(string(saved), "Great") {
+		t.Error("Created record was not persisted to the database file")
+	}
+	if !fServer.IsInternalWrite(filepath.Join(tempDir, "db.json")) {
+		t.Error("Database write was not marked as an internal write")
+	}
+	if len(events) != 1 || events[0] != "mock-api" {
+		t.Error("Mock API change event was not sent")
+	}
+
+	resp = getWithEncoding(t, fServer.Url()+"/api/profile", "")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "webby") {
+		t.Errorf("Singular resource was not returned: %s", body)
+	}
+
+	requests := []struct{ method, path, body string }{
+		{"POST", "/api/posts", "null"},
+		{"POST", "/api/posts", "[]"},
+		{"POST", "/api/posts", "42"},
+		{"PUT", "/api/posts/1", "null"},
+		{"PATCH", "/api/profile", `"webby"`},
+		{"POST", "/api/title", "{}"},
+	}
+	ioutil.WriteFile(filepath.Join(tempDir, "db.json"), []byte(`{"posts": [{"id": 1}], "profile": {}, "title": "Blog"}`), 0644)
+	for _, request := range requests {
+		req, _ := http.NewRequest(request.method, fServer.Url()+request.path, strings.NewReader(request.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected %s %s with %s to be rejected, got %d", request.method, request.path, request.body, resp.StatusCode)
+		}
+	}
+	saved, _ = ioutil.ReadFile(filepath.Join(tempDir, "db.json"))
+	if !strings.Contains(string(saved), `"title": "Blog"`) {
+		t.Errorf("Expected rejected requests to leave the database unchanged, got %s", saved)
+	}
+}
+
+func TestFormCapture(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+	fServer.SetForms(&FormConfig{Paths: []string{"/contact"}, Redirect: "/thanks.html"})
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("email", "dev@example.com")
+	part, _ := writer.CreateFormFile("attachment", "notes.txt")
+	part.Write([]byte("Some notes"))
+	writer.Close()
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Post(fServer.Url()+"/contact", writer.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/thanks.html" {
+		t.Errorf("Form post was not redirected, got status %d", resp.StatusCode)
+	}
+
+	submissions, _ := fServer.ListFormSubmissions()
+	if len(submissions) != 1 || submissions[0].Fields["email"][0] != "dev@example.com" || len(submissions[0].Files) != 1 {
+		t.Fatal("Form submission was not captured")
+	}
+
+	fPath, _ := fServer.FormFilePath(submissions[0].ID, submissions[0].Files[0].StoredName)
+	stored, _ := ioutil.ReadFile(fPath)
+	if string(stored) != "Some notes" {
+		t.Error("Uploaded file was not stored")
+	}
+
+	rel, _ := filepath.Rel(tempDir, fPath)
+	for _, rPath := range []string{"/" + filepath.ToSlash(rel), "/.WEBBY/forms/", "/x/../.webby/forms/"} {
+		resp, _ = http.Get(fServer.Url() + rPath)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Stored form data was served at %s, got status %d", rPath, resp.StatusCode)
+		}
+	}
+
+	fServer.SetForms(&FormConfig{CaptureAll: true})
+	resp, _ = http.Post(fServer.Url()+"/contact", "application/x-www-form-urlencoded", strings.NewReader(strings.Repeat("a", maxFormSize+1)))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected an oversized form post to be rejected, got status %d", resp.StatusCode)
+	}
+
+	ioutil.WriteFile(filepath.Join(tempDir, "post.cgi"), []byte("#!/bin/sh\necho 'Content-Type: text/plain'\necho ''\necho \"$REQUEST_METHOD script\"\n"), 0755)
+	fServer.AddScriptHandler(&ScriptHandler{Extension: ".cgi", CGI: "/bin/sh"})
+	resp, _ = http.Post(fServer.Url()+"/post.cgi", "application/x-www-form-urlencoded", strings.NewReader("name=webby"))
+	output, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(output), "POST script") {
+		t.Errorf("Expected posts to scripts to skip form capture, got %q", string(output))
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	os.Mkdir(filepath.Join(tempDir, "functions"), 0755)
+	ioutil.WriteFile(filepath.Join(tempDir, "functions", "hello.js"), []byte(`
+		exports.handler = async function(request) {
+			return {status: 201, headers: {"X-Name": request.query.name}, body: {greeting: "Hello " + request.query.name}};
+		};
+	`), 0644)
+	ioutil.WriteFile(filepath.Join(tempDir, "functions", "broken.js"), []byte(`module.exports = function() { throw new Error("Oops"); };`), 0644)
+	ioutil.WriteFile(filepath.Join(tempDir, "functions", "status.js"), []byte(`module.exports = function() { return {status: "oops"}; };`), 0644)
+	ioutil.WriteFile(filepath.Join(tempDir, "functions", "continue.js"), []byte(`module.exports = function() { return {status: 100}; };`), 0644)
+	fServer.SetFunctions(&FunctionsConfig{})
+
+	resp := getWithEncoding(t, fServer.Url()+"/api/hello?name=Webby", "")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 201 || resp.Header.Get("X-Name") != "Webby" || string(body) != `{"greeting":"Hello Webby"}` {
+		t.Errorf("Unexpected function response %d: %s", resp.StatusCode, body)
+	}
+
+	resp = getWithEncoding(t, fServer.Url()+"/api/broken", "")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || !strings.Contains(string(body), "Oops") {
+		t.Errorf("Function error was not returned: %s", body)
+	}
+
+	resp = getWithEncoding(t, fServer.Url()+"/api/status", "")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || !strings.Contains(string(body), "invalid status") {
+		t.Errorf("Expected an invalid function status to be reported, got %d: %s", resp.StatusCode, body)
+	}
+
+	resp = getWithEncoding(t, fServer.Url()+"/api/continue", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected an informational function status to be rejected, got %d", resp.StatusCode)
+	}
+
+	resp = getWithEncoding(t, fServer.Url()+"/functions/hello.js", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Function source was served, got status %d", resp.StatusCode)
+	}
+}
+
+func TestScripts(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	script := "#!/bin/sh\necho 'Content-Type: text/html'\necho ''\necho \"<body><p>$QUERY_STRING</p></body>\"\n"
+	ioutil.WriteFile(filepath.Join(tempDir, "hello.cgi"), []byte(script), 0755)
+	if err := fServer.AddScriptHandler(&ScriptHandler{Extension: ".cgi"}); err == nil {
+		t.Error("Expected script handlers without an interpreter to be rejected, rather than running scripts directly")
+	}
+	fServer.AddScriptHandler(&ScriptHandler{Extension: ".cgi", CGI: "/bin/sh"})
+
+	resp := getWithEncoding(t, fServer.Url()+"/hello.cgi?name=webby", "")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "<p>name=webby</p>") || !strings.Contains(string(body), "livereload.js\"></script>\n</body>") {
+		t.Errorf("CGI script output was not served with live reload, got %q", string(body))
+	}
+
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	go fcgi.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		env := fcgi.ProcessEnv(r)
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("<p>" + env["SCRIPT_FILENAME"] + "</p>"))
+	}))
+
+	ioutil.WriteFile(filepath.Join(tempDir, "index.php"), []byte("<?php"), 0644)
+	fServer.AddScriptHandler(&ScriptHandler{Extension: ".php", FastCGI: listener.Addr().String()})
+
+	resp = getWithEncoding(t, fServer.Url()+"/", "")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || !strings.Contains(string(body), filepath.Join(tempDir, "index.php")) {
+		t.Errorf("FastCGI index script was not served, got %d %q", resp.StatusCode, string(body))
+	}
+
+	for _, status := range []string{"oops", "1000 Oops", "42", "101 Switching Protocols"} {
+		if err := writeCGIResponse(httptest.NewRecorder(), []byte("Status: "+status+"\r\n\r\n")); err == nil {
+			t.Errorf("Expected script status %q to be rejected", status)
+		}
+	}
+}
+
+func TestRealtimeEndpoints(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	fServer.AddRealtimeEndpoint(&RealtimeEndpoint{Type: RealtimeBroadcast, Path: "/ws"})
+	wsURL := strings.Replace(fServer.Url(), "http", "ws", 1) + "/ws"
+	first, err := websocket.Dial(wsURL, "", fServer.Url())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer first.Close()
+	second, err := websocket.Dial(wsURL, "", fServer.Url())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer second.Close()
+
+	websocket.Message.Send(first, "hello")
+	var message string
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := websocket.Message.Receive(second, &message); err != nil || message != "hello" {
+		t.Errorf("Broadcast message was not received, got %q %v", message, err)
+	}
+
+	events := "{\"event\": \"tick\", \"data\": {\"count\": 1}}\n{\"id\": \"2\", \"data\": \"done\", \"delay\": 10}\n"
+	ioutil.WriteFile(filepath.Join(tempDir, "events.ndjson"), []byte(events), 0644)
+	fServer.AddRealtimeEndpoint(&RealtimeEndpoint{Type: RealtimeSSE, Path: "/events", File: "events.ndjson"})
+
+	resp := getWithEncoding(t, fServer.Url()+"/events", "gzip")
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", resp.Header.Get("Content-Type"))
+	}
+
+	expected := "event: tick\ndata: {\"count\": 1}\n\nid: 2\ndata: done\n\n"
+	body := make([]byte, len(expected))
+	if _, err := io.ReadFull(resp.Body, body); err != nil || string(body) != expected {
+		t.Errorf("Unexpected event stream output %q", string(body))
+	}
+
+	ended := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(ioutil.Discard, resp.Body)
+		ended <- err
+	}()
+	fServer.RemoveRealtimeEndpoint(1)
+	select {
+	case <-ended:
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the event stream to end when its endpoint was removed")
+	}
+
+	fServer.AddRealtimeEndpoint(&RealtimeEndpoint{Type: RealtimeSSE, Path: "/events", File: "events.ndjson", Loop: true})
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", fServer.Url()+"/events", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if count := strings.Count(string(body), "event: tick"); count != 1 {
+		t.Errorf("Expected looping stream to wait before replaying its events, got %d replays", count)
+	}
+
+	ioutil.WriteFile(filepath.Join(tempDir, "events.ndjson"), []byte("{\"event\": \"tick\\ndata: injected\", \"data\": 1}\n"), 0644)
+	if _, err := readStreamEvents(filepath.Join(tempDir, "events.ndjson")); err == nil {
+		t.Error("Expected event names containing line breaks to be rejected")
+	}
+}
+
+func TestIncludesAndLayouts(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	os.MkdirAll(filepath.Join(tempDir, "partials"), 0755)
+	os.MkdirAll(filepath.Join(tempDir, "_data"), 0755)
+	ioutil.WriteFile(filepath.Join(tempDir, "partials", "header.html"), []byte("<header>Site header</header>"), 0644)
+	ioutil.WriteFile(filepath.Join(tempDir, "_data", "site.json"), []byte(`{"name": "Webby"}`), 0644)
+	ioutil.WriteFile(filepath.Join(tempDir, "layout.html"), []byte("<title>{{.Page.title}} - {{.Data.site.name}}</title><!--#include virtual=\"/partials/header.html\" -->{{.Content}}"), 0644)
+	ioutil.WriteFile(filepath.Join(tempDir, "about.html"), []byte("---\ntitle: About\nlayout: layout.html\n---\n<p>{{.Page.title}} page</p>"), 0644)
+
+	resp := getWithEncoding(t, fServer.Url()+"/about.html", "")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	expected := "<title>About - Webby</title><header>Site header</header><p>About page</p>"
+	if !strings.HasPrefix(string(body), expected) {
+		t.Errorf("Expected rendered page to start with %q, got %q", expected, string(body))
+	}
+
+	fServer.mutex.RLock()
+	pages := fServer.dependencies[filepath.Join(tempDir, "partials", "header.html")]
+	fServer.mutex.RUnlock()
+	if len(pages) != 1 || !pages[filepath.Join(tempDir, "about.html")] {
+		t.Errorf("Expected include dependency to be recorded for about.html, got %v", pages)
+	}
+
+	revalidate := func() int {
+		req, _ := http.NewRequest(http.MethodGet, fServer.Url()+"/about.html", nil)
+		req.Header.Set("If-Modified-Since", resp.Header.Get("Last-Modified"))
+		revalidated, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		revalidated.Body.Close()
+		return revalidated.StatusCode
+	}
+	if status := revalidate(); status != http.StatusNotModified {
+		t.Errorf("Expected an unchanged page to revalidate, got status %d", status)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(tempDir, "partials", "header.html"), later, later)
+	if status := revalidate(); status != http.StatusOK {
+		t.Errorf("Expected a page with a changed include to be served again, got status %d", status)
+	}
+}
+
+func TestMarkdownRendering(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	source := "```sh\n# Not a title\n```\n\n# Notes *today*\n\n| A | B |\n|---|---|\n| 1 | 2 |\n\n- [x] Done\n\n```go\nfunc main() {}\n```\n"
+	ioutil.WriteFile(filepath.Join(tempDir, "notes.md"), []byte(source), 0644)
+
+	resp := getWithEncoding(t, fServer.Url()+"/notes.md", "")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	for _, expected := range []string{"<title>Notes today</title>", `<h1 id="notes-today">`, "<table>", `type="checkbox"`, "<pre style=", "livereload.js\"></script>\n</body>"} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Rendered markdown did not contain %q", expected)
+		}
+	}
+
+	resp = getWithEncoding(t, fServer.Url()+"/notes.md?raw", "")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != source {
+		t.Errorf("Expected raw markdown source, got %q", string(body))
+	}
+}
+
+func TestTranspile(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	ioutil.WriteFile(filepath.Join(tempDir, "greet.ts"), []byte("export const greet = (name: string): string => 'Hello ' + name;\n"), 0644)
+	ioutil.WriteFile(filepath.Join(tempDir, "view.jsx"), []byte("export const view = <p>Hello</p>;\n"), 0644)
+	ioutil.WriteFile(filepath.Join(tempDir, "main.ts"), []byte("import { greet } from './greet';\nconsole.log(greet('webby'));\n"), 0644)
+	ioutil.WriteFile(filepath.Join(tempDir, "broken.ts"), []byte("const count: = 1;\n"), 0644)
+	fServer.SetTranspile(&TranspileConfig{Bundle: []string{"main.ts"}, JSXFactory: "h"})
+
+	expected := map[string]string{
+		"/greet.ts": "export const greet = (name) => \"Hello \" + name;",
+		"/view.jsx": "export const view = /* @__PURE__ */ h(\"p\", null, \"Hello\");",
+		"/main.ts":  "console.log(greet(\"webby\"));",
+	}
+	for path, code := range expected {
+		resp := getWithEncoding(t, fServer.Url()+path, "")
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/javascript") || !strings.Contains(string(body), code) {
+			t.Errorf("Expected %s to be compiled to JavaScript containing %q, got %d %q", path, code, resp.StatusCode, string(body))
+		}
+		if path == "/main.ts" && (strings.Contains(string(body), "import ") || !strings.Contains(string(body), "var greet = ")) {
+			t.Errorf("Expected %s to be bundled with its imports, got %q", path, string(body))
+		}
+	}
+
+	resp := getWithEncoding(t, fServer.Url()+"/broken.ts", "")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Webby-Compile-Error") != "broken.ts" || !strings.Contains(string(body), "webby:compile-error") || !strings.Contains(string(body), `"line":1`) {
+		t.Errorf("Expected a compile error script for broken.ts, got %d %q", resp.StatusCode, string(body))
+	}
+
+	// Servers of the same folder keep their own output for their own options
+	other, err := StartFileServer(tempDir, &util.Options{ManagerPort: 35729})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer other.Destroy()
+	other.SetTranspile(&TranspileConfig{JSXFactory: "createElement"})
+	resp = getWithEncoding(t, other.Url()+"/view.jsx", "")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "createElement(\"p\"") {
+		t.Errorf("Expected the other server to use its own JSX factory, got %q", string(body))
+	}
+	resp = getWithEncoding(t, fServer.Url()+"/view.jsx", "")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), expected["/view.jsx"]) {
+		t.Errorf("Expected the first server to keep its JSX factory, got %q", string(body))
+	}
+}
+
+func TestImportMapInjection(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	packages := map[string]string{
+		"lodash-es":    `{"main": "lodash.js", "module": "lodash.js"}`,
+		"@scope/pkg":   `{"exports": {".": {"require": "./cjs/index.js", "import": "./esm/index.js"}, "./utils": "./esm/utils.js"}}`,
+		"app-override": `{"main": "index.js"}`,
+	}
+	for name, manifest := range packages {
+		os.MkdirAll(filepath.Join(tempDir, "node_modules", name), 0755)
+		ioutil.WriteFile(filepath.Join(tempDir, "node_modules", name, "package.json"), []byte(manifest), 0644)
+	}
+
+	html := `<html><head><script type="importmap">{"imports": {"app-override": "/vendor/app.js"}, "integrity": {"/vendor/app.js": "sha384-abc"}}</script></head><body></body></html>`
+	ioutil.WriteFile(filepath.Join(tempDir, "index.html"), []byte(html), 0644)
+
+	resp := getWithEncoding(t, fServer.Url()+"/", "")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	match := importMapRegex.FindSubmatch(body)
+	if match == nil {
+		t.Fatalf("No import map found in %q", string(body))
+	}
+	var result importMap
+	json.Unmarshal(match[2], &result)
+
+	expected := map[string]string{
+		"lodash-es":        "/node_modules/lodash-es/lodash.js",
+		"@scope/pkg":       "/node_modules/@scope/pkg/esm/index.js",
+		"@scope/pkg/utils": "/node_modules/@scope/pkg/esm/utils.js",
+		"@scope/pkg/":      "/node_modules/@scope/pkg/",
+		"app-override":     "/vendor/app.js",
+	}
+	for specifier, url := range expected {
+		if result.Imports[specifier] != url {
+			t.Errorf("Expected %s to map to %s, got %q", specifier, url, result.Imports[specifier])
+		}
+	}
+	if !strings.Contains(string(match[2]), `"sha384-abc"`) {
+		t.Errorf("Existing import map keys were not kept, got %q", string(match[2]))
+	}
+
+	// Upgrade a package in place and install another within a scope folder
+	ioutil.WriteFile(filepath.Join(tempDir, "node_modules", "lodash-es", "package.json"), []byte(`{"module": "lodash.mjs"}`), 0644)
+	os.MkdirAll(filepath.Join(tempDir, "node_modules", "@scope", "other"), 0755)
+	ioutil.WriteFile(filepath.Join(tempDir, "node_modules", "@scope", "other", "package.json"), []byte(`{"main": "other.js"}`), 0644)
+	later := time.Now().Add(time.Minute)
+	ioutil.WriteFile(filepath.Join(tempDir, "package-lock.json"), []byte(`{}`), 0644)
+	os.Chtimes(filepath.Join(tempDir, "package-lock.json"), later, later)
+	os.Chtimes(filepath.Join(tempDir, "node_modules"), time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+
+	resp = getWithEncoding(t, fServer.Url()+"/", "")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	result = importMap{}
+	json.Unmarshal(importMapRegex.FindSubmatch(body)[2], &result)
+	if result.Imports["lodash-es"] != "/node_modules/lodash-es/lodash.mjs" || result.Imports["@scope/other"] != "/node_modules/@scope/other/other.js" {
+		t.Errorf("Expected the import map to update after package changes, got %v", result.Imports)
+	}
+}
+
+func TestProductionPreview(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	html := "<html>\n    <body>\n        <p>Hello</p>\n    </body>\n</html>\n"
+	ioutil.WriteFile(filepath.Join(tempDir, "index.html"), []byte(html), 0644)
+	fServer.SetProductionPreview(true)
+
+	resp := getWithEncoding(t, fServer.Url()+"/", "")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if strings.Contains(string(body), "livereload.js") {
+		t.Error("Live reload was injected in production preview mode")
+	}
+	if len(body) >= len(html) || !strings.Contains(string(body), "<p>Hello</p>") {
+		t.Errorf("Expected minified HTML, got %q", string(body))
+	}
+	if resp.Header.Get("Cache-Control") == "no-cache" {
+		t.Error("Expected caching headers in production preview mode")
+	}
+
+	stats := fServer.MinifyStats()
+	if len(stats) != 1 || stats[0].Original != len(html) || stats[0].Minified != len(body) {
+		t.Errorf("Unexpected minify stats %+v", stats)
+	}
+
+	// Static files are minified again only once changed on disk
+	cssPath := filepath.Join(tempDir, "style.css")
+	ioutil.WriteFile(cssPath, []byte("body {\n    color: red;\n}\n"), 0644)
+	getCSS := func() string {
+		resp := getWithEncoding(t, fServer.Url()+"/style.css", "")
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return string(body)
+	}
+	if css := getCSS(); css != "body{color:red}" {
+		t.Errorf("Expected minified CSS, got %q", css)
+	}
+	fServer.mutex.RLock()
+	cached := fServer.minifyCache["/style.css"]
+	fServer.mutex.RUnlock()
+	if cached == nil || cached.modTime.IsZero() {
+		t.Error("Expected static file output to be cached by modification time")
+	}
+	ioutil.WriteFile(cssPath, []byte("body {\n    color: blue;\n}\n"), 0644)
+	os.Chtimes(cssPath, time.Now(), time.Now().Add(time.Minute))
+	if css := getCSS(); css != "body{color:blue}" {
+		t.Errorf("Expected changed CSS to be minified again, got %q", css)
+	}
+
+	for i := 0; i < maxMinifyCacheEntries+10; i++ {
+		fServer.minify("/page-"+strconv.Itoa(i)+".css", "text/css", []byte("a { }"), time.Time{})
+	}
+	fServer.mutex.RLock()
+	cacheSize, statsSize := len(fServer.minifyCache), len(fServer.minifyStats)
+	fServer.mutex.RUnlock()
+	if cacheSize != maxMinifyCacheEntries || statsSize != maxMinifyCacheEntries {
+		t.Errorf("Expected the minify cache to be limited to %d paths, got %d", maxMinifyCacheEntries, cacheSize)
+	}
+}
+
+func TestImageVariants(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	file, _ := os.Create(filepath.Join(tempDir, "photo.png"))
+	png.Encode(file, image.NewRGBA(image.Rect(0, 0, 200, 100)))
+	file.Close()
+
+	sizes := map[string][2]int{
+		"?w=50":                {50, 25},
+		"?w=40&h=40":           {40, 20},
+		"?w=40&h=40&fit=cover": {40, 40},
+		"?w=40&h=10&fit=fill":  {40, 10},
+	}
+	for query, size := range sizes {
+		resp := getWithEncoding(t, fServer.Url()+"/photo.png"+query, "")
+		config, _, err := image.DecodeConfig(resp.Body)
+		resp.Body.Close()
+		if err != nil || config.Width != size[0] || config.Height != size[1] {
+			t.Errorf("Expected %s to be %dx%d, got %dx%d %v", query, size[0], size[1], config.Width, config.Height, err)
+		}
+	}
+
+	// Only the header of an oversized image is needed, since it should be rejected before decoding
+	header := []byte("IHDR\x00\x00\x27\x10\x00\x00\x27\x10\x08\x06\x00\x00\x00")
+	huge := append([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0d"), header...)
+	huge = binary.BigEndian.AppendUint32(huge, crc32.ChecksumIEEE(header))
+	ioutil.WriteFile(filepath.Join(tempDir, "huge.png"), huge, 0644)
+	resp := getWithEncoding(t, fServer.Url()+"/huge.png?w=50", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected oversized image to be rejected, got status %d", resp.StatusCode)
+	}
+	cached, _ := ioutil.ReadDir(filepath.Join(tempDir, imageCacheDir))
+	if len(cached) != len(sizes) {
+		t.Errorf("Expected only the %d generated variants to be cached, found %d files", len(sizes), len(cached))
+	}
+
+	ioutil.WriteFile(filepath.Join(tempDir, "photo.webp"), []byte("webp-data"), 0644)
+	fServer.SetModernImages(true)
+
+	req, _ := http.NewRequest("GET", fServer.Url()+"/photo.png", nil)
+	req.Header.Set("Accept", "image/avif,image/webp,*/*")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "webp-data" || !util.StringInSlice("Accept", resp.Header.Values("Vary")) {
+		t.Errorf("Expected webp sibling to be served with Vary: Accept, got %q", string(body))
+	}
+}
+
+func TestUtilityEndpoints(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	resp := getWithEncoding(t, fServer.Url()+"/__webby/placeholder/120x80?bg=1e90ff", "")
+	config, format, err := image.DecodeConfig(resp.Body)
+	resp.Body.Close()
+	if err != nil || format != "png" || config.Width != 120 || config.Height != 80 {
+		t.Errorf("Expected a 120x80 png placeholder, got %s %dx%d %v", format, config.Width, config.Height, err)
+	}
+
+	resp = getWithEncoding(t, fServer.Url()+"/__webby/lorem/words/5", "")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.TrimSpace(string(body)) != "lorem ipsum dolor sit amet" {
+		t.Errorf("Expected five lorem words, got %q", string(body))
+	}
+
+	resp = getWithEncoding(t, fServer.Url()+"/__webby/fake/3?id=id&email=email&role=admin|editor", "")
+	var records []map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&records)
+	resp.Body.Close()
+	if len(records) != 3 || records[2]["id"] != float64(3) || !strings.HasSuffix(records[0]["email"].(string), "@example.com") {
+		t.Errorf("Expected three fake records, got %v", records)
+	}
+
+	var seeded []string
+	for i := 0; i < 2; i++ {
+		resp = getWithEncoding(t, fServer.Url()+"/__webby/fake?seed=7&a=w|x|y|z&b=w|x|y|z&c=w|x|y|z&d=w|x|y|z", "")
+		body, _ = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		seeded = append(seeded, string(body))
+	}
+	if seeded[0] != seeded[1] {
+		t.Errorf("Expected the same seed to give the same fake records, got %s and %s", seeded[0], seeded[1])
+	}
+
+	start := time.Now()
+	resp = getWithEncoding(t, fServer.Url()+"/__webby/status/503?delay=100ms", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || time.Since(start) < 100*time.Millisecond {
+		t.Errorf("Expected a delayed 503 response, got %d", resp.StatusCode)
+	}
+}
+
+func TestThrottling(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	ioutil.WriteFile(filepath.Join(tempDir, "data.txt"), bytes.Repeat([]byte("a"), 300), 0644)
+	timeRequest := func() time.Duration {
+		start := time.Now()
+		resp := getWithEncoding(t, fServer.Url()+"/data.txt", "")
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return time.Since(start)
+	}
+
+	// 8kbps sends 100 bytes per tick so the file takes three ticks after the latency
+	err := fServer.SetThrottle(&ThrottleConfig{Latency: 100, Bandwidth: 8})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if elapsed := timeRequest(); elapsed < 100*time.Millisecond+3*throttleTick {
+		t.Errorf("Expected throttled response to take at least 400ms, took %s", elapsed)
+	}
+
+	fServer.SetThrottle(&ThrottleConfig{Latency: 1000, ContentTypes: []string{"image/"}})
+	if elapsed := timeRequest(); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected text response to not be throttled, took %s", elapsed)
+	}
+
+	if err := fServer.SetThrottle(&ThrottleConfig{Profile: "dial-up"}); err == nil {
+		t.Error("Expected unknown throttle profile to be rejected")
+	}
+
+	// Throttled responses stop as soon as the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest("GET", "/data.txt", nil).WithContext(ctx)
+	tw := newThrottleResponseWriter(httptest.NewRecorder(), r, &ThrottleConfig{Latency: 5000, Bandwidth: 8})
+	start := time.Now()
+	if _, err := tw.Write(bytes.Repeat([]byte("a"), 300)); err == nil || time.Since(start) > time.Second {
+		t.Errorf("Expected a throttled write to end once the client went away, took %s", time.Since(start))
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	ioutil.WriteFile(filepath.Join(tempDir, "data.json"), bytes.Repeat([]byte("a"), 100), 0644)
+	ioutil.WriteFile(filepath.Join(tempDir, "page.txt"), []byte("page"), 0644)
+
+	fServer.AddFaultRule(&FaultRule{Path: "*.json", Type: "status", Status: 503, Percent: 100})
+	resp := getWithEncoding(t, fServer.Url()+"/data.json", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected faults to be inactive until enabled, got %d", resp.StatusCode)
+	}
+
+	fServer.SetFaultsEnabled(true)
+	resp = getWithEncoding(t, fServer.Url()+"/data.json", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected injected 503 status, got %d", resp.StatusCode)
+	}
+
+	resp = getWithEncoding(t, fServer.Url()+"/page.txt", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected non-matching path to be served, got %d", resp.StatusCode)
+	}
+
+	fServer.RemoveFaultRule(0)
+	fServer.AddFaultRule(&FaultRule{Path: "*.json", Type: "truncate", Percent: 100})
+	resp = getWithEncoding(t, fServer.Url()+"/data.json", "")
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err == nil || len(body) != 50 {
+		t.Errorf("Expected body to be cut off at 50 bytes with an error, got %d bytes %v", len(body), err)
+	}
+
+	fServer.RemoveFaultRule(0)
+	fServer.AddFaultRule(&FaultRule{Path: "*.json", Type: "drop", Percent: 100})
+	req, _ := http.NewRequest("GET", fServer.Url()+"/data.json", nil)
+	if _, err := http.DefaultTransport.RoundTrip(req); err == nil {
+		t.Error("Expected dropped connection to fail the request")
+	}
+}
+
+func TestRequestInspector(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	ioutil.WriteFile(filepath.Join(tempDir, "page.txt"), []byte("hello"), 0644)
+
+	req, _ := http.NewRequest("GET", fServer.Url()+"/page.txt?a=1", nil)
+	req.Header.Set("User-Agent", "webby-test")
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	resp.Body.Close()
+	getWithEncoding(t, fServer.Url()+"/missing.txt", "").Body.Close()
+
+	records := fServer.RequestLog(0)
+	if len(records) != 2 {
+		t.Fatalf("Expected two recorded requests, got %d", len(records))
+	}
+	first := records[0]
+	if first.Method != "GET" || first.Path != "/page.txt" || first.Status != 200 || first.Size != 5 || first.UserAgent != "webby-test" || first.ClientIP != "127.0.0.1" {
+		t.Errorf("Unexpected request record %+v", first)
+	}
+	if !strings.HasSuffix(first.URL, "/page.txt?a=1") || first.ResponseHeaders.Get("Content-Type") == "" {
+		t.Errorf("Expected full URL and response headers to be recorded, got %+v", first)
+	}
+
+	if newer := fServer.RequestLog(first.ID); len(newer) != 1 || newer[0].Status != 404 {
+		t.Errorf("Expected only the later 404 request after the first ID, got %v", newer)
+	}
+
+	// Handlers writing nothing are sent as 200 unless the client went away first
+	empty := httptest.NewRequest("GET", "/empty", nil)
+	fServer.recordRequest(empty, &inspectResponseWriter{ResponseWriter: httptest.NewRecorder()}, time.Now())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fServer.recordRequest(empty.WithContext(ctx), &inspectResponseWriter{ResponseWriter: httptest.NewRecorder()}, time.Now())
+	records = fServer.RequestLog(0)
+	if len(records) != 4 || records[2].Status != 200 || records[3].Status != 0 {
+		t.Errorf("Expected an empty response as 200 and an aborted request as 0, got %+v", records)
+	}
+	if _, ok := fServer.Stats().StatusCodes[0]; ok {
+		t.Error("Expected aborted requests not to be counted under a status code")
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	ioutil.WriteFile(filepath.Join(tempDir, "page.txt"), []byte("hello"), 0644)
+	if err := fServer.SetAccessLog(&AccessLogConfig{Format: "common"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	getWithEncoding(t, fServer.Url()+"/page.txt?a=1", "").Body.Close()
+
+	data, _ := ioutil.ReadFile(filepath.Join(tempDir, ".webby", "access.log"))
+	line := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(line, "127.0.0.1 - - [") || !strings.HasSuffix(line, `"GET /page.txt?a=1 HTTP/1.1" 200 5`) {
+		t.Errorf("Expected a common log format line, got %q", line)
+	}
+
+	time.Sleep(internalWriteWindow)
+	if !fServer.IsInternalWrite(filepath.Join(tempDir, ".webby", "access.log")) {
+		t.Error("Expected writes to the access log never to reload open pages")
+	}
+}
+
+func TestServerStats(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	if stats := fServer.Stats(); stats.Requests != 0 || stats.LastAccessAgo() != "never" {
+		t.Errorf("Expected a new server to have no stats, got %+v", stats)
+	}
+
+	ioutil.WriteFile(filepath.Join(tempDir, "page.txt"), []byte("hello"), 0644)
+	getWithEncoding(t, fServer.Url()+"/page.txt", "").Body.Close()
+	getWithEncoding(t, fServer.Url()+"/page.txt", "").Body.Close()
+	getWithEncoding(t, fServer.Url()+"/missing.txt", "").Body.Close()
+	fServer.AddLiveReloadClient(1)
+
+	// Requests are counted once handled, which may be just after the client has its response
+	stats := fServer.Stats()
+	for i := 0; i < 20 && stats.Requests < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+		stats = fServer.Stats()
+	}
+	if stats.Requests != 3 || stats.StatusCodes[200] != 2 || stats.StatusCodes[404] != 1 {
+		t.Errorf("Expected three counted requests, got %+v", stats)
+	}
+	if stats.BytesServed < 10 || stats.LastAccess.IsZero() || stats.LiveReloadClients != 1 {
+		t.Errorf("Expected bytes, last access and live reload clients to be tracked, got %+v", stats)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	fServer, tempDir := getTestFileServer(t)
+	defer os.RemoveAll(tempDir)
+	defer fServer.Destroy()
+
+	later := time.Now().Add(6 * time.Minute)
+	if fServer.IsIdle(later) {
+		t.Error("Expected a server without an idle timeout never to be idle")
+	}
+
+	fServer.SetIdleTimeout(5)
+	if !fServer.IsIdle(later) || fServer.IsIdle(time.Now().Add(4*time.Minute)) {
+		t.Error("Expected the server to only be idle once its timeout has passed")
+	}
+
+	fServer.AddLiveReloadClient(1)
+	if fServer.IsIdle(later) {
+		t.Error("Expected a server with connected live reload clients not to be idle")
+	}
+}
+
+func TestConcurrentServerStarts(t *testing.T) {
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+
+	servers := make([]*FileServer, 5)
+	var wg sync.WaitGroup
+	for i := range servers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			servers[i], _ = StartFileServer(tempDir, &util.Options{})
+		}(i)
+	}
+	wg.Wait()
+
+	ports := make(map[int]bool)
+	ids := make(map[int]bool)
+	for _, server := range servers {
+		if server == nil {
+			t.Fatal("Expected all servers to start")
+		}
+		defer server.Destroy()
+		ports[server.Port] = true
+		ids[server.ID] = true
+	}
+	if len(ports) != len(servers) || len(ids) != len(servers) {
+		t.Errorf("Expected servers started together to have their own ports and IDs, got %v %v", ports, ids)
+	}
+}
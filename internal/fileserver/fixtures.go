@@ -0,0 +1,348 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Fixture modes control how proxied responses are recorded and replayed.
+const (
+	FixtureModeOff           = ""
+	FixtureModeRecord        = "record"
+	FixtureModeReplay        = "replay"
+	FixtureModeRecordMissing = "record-missing"
+)
+
+// fixtureDir is the folder, relative to the server root, where fixtures are stored.
+var fixtureDir = filepath.Join(webbyDir, "fixtures")
+
+// fixtureRequestKey marks proxied requests handled with fixtures, which are recorded as sent
+// by upstream so live reload is injected on the way to the client instead of by the proxy.
+type fixtureRequestKey struct{}
+
+// fixtureNameRegex matches characters not suitable for use in fixture file names.
+var fixtureNameRegex = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// skippedFixtureHeaders are response headers that are not stored in fixtures
+// since they describe the transfer rather than the response.
+var skippedFixtureHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Content-Encoding":  true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+	"Vary":              true,
+	"Date":              true,
+}
+
+// Fixture is a recorded upstream response stored as a JSON file in the project.
+type Fixture struct {
+	File     string      `json:"-"`
+	Method   string      `json:"method"`
+	Path     string      `json:"path"`
+	Query    string      `json:"query"`
+	BodyHash string      `json:"body_hash,omitempty"`
+	Status   int         `json:"status"`
+	Headers  http.Header `json:"headers"`
+	Body     string      `json:"body"`
+	Base64   bool        `json:"base64,omitempty"`
+}
+
+// IsValidFixtureMode checks if the given string is a known fixture mode.
+func IsValidFixtureMode(mode string) bool {
+	switch mode {
+	case FixtureModeOff, FixtureModeRecord, FixtureModeReplay, FixtureModeRecordMissing:
+		return true
+	}
+	return false
+}
+
+// SetFixtureMode sets how proxied responses are recorded and replayed.
+func (fs *FileServer) SetFixtureMode(mode string) error {
+	if !IsValidFixtureMode(mode) {
+		return fmt.Errorf("unknown fixture mode %q", mode)
+	}
+
+	fs.mutex.Lock()
+	fs.FixtureMode = mode
+	fs.mutex.Unlock()
+	return nil
+}
+
+func (fs *FileServer) fixtureMode() string {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+	return fs.FixtureMode
+}
+
+// FixturePath provides the absolute path of the folder the server stores fixtures in.
+func (fs *FileServer) FixturePath() string {
+	return filepath.Join(fs.RootPath, fixtureDir)
+}
+
+// ListFixtures loads all recorded fixtures for the server, sorted by path.
+func (fs *FileServer) ListFixtures() ([]*Fixture, error) {
+	files, err := ioutil.ReadDir(fs.FixturePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var fixtures []*Fixture
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		fixture, err := fs.loadFixture(file.Name())
+		if err != nil {
+			fs.logFor(nil).Error("Loading fixture "+file.Name(), err)
+			continue
+		}
+		fixtures = append(fixtures, fixture)
+	}
+
+	sort.Slice(fixtures, func(i, j int) bool {
+		return fixtures[i].Path+fixtures[i].Method < fixtures[j].Path+fixtures[j].Method
+	})
+	return fixtures, nil
+}
+
+// ReadFixtureFile provides the raw JSON content of the given fixture file.
+func (fs *FileServer) ReadFixtureFile(name string) ([]byte, error) {
+	fPath, err := fs.fixtureFilePath(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(fPath)
+}
+
+// SaveFixtureFile validates and stores the given JSON content for the given fixture file.
+func (fs *FileServer) SaveFixtureFile(name string, data []byte) error {
+	fPath, err := fs.fixtureFilePath(name)
+	if err != nil {
+		return err
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return fmt.Errorf("invalid fixture JSON: %s", err.Error())
+	}
+
+	return ioutil.WriteFile(fPath, data, 0644)
+}
+
+// DeleteFixture removes the given fixture file.
+func (fs *FileServer) DeleteFixture(name string) error {
+	fPath, err := fs.fixtureFilePath(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(fPath)
+}
+
+// serveWithFixtures serves the given request via the given proxy handler,
+// recording or replaying the response depending on the server fixture mode.
+// Live reload is injected into HTML responses if the proxy would inject it.
+func (fs *FileServer) serveWithFixtures(w http.ResponseWriter, r *http.Request, proxy http.Handler, injectLiveReload bool) {
+	mode := fs.fixtureMode()
+	if mode == FixtureModeOff || r.Header.Get("Upgrade") != "" {
+		proxy.ServeHTTP(w, r)
+		return
+	}
+
+	if injectLiveReload && fs.liveReloadEnabled() {
+		iw := &injectingResponseWriter{ResponseWriter: w, scriptTag: fs.liveReloadScriptTag(r.Host)}
+		defer iw.Close()
+		w = iw
+	}
+	r = r.WithContext(context.WithValue(r.Context(), fixtureRequestKey{}, true))
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	name := fixtureFileName(r, body)
+	if mode == FixtureModeReplay || mode == FixtureModeRecordMissing {
+		fixture, err := fs.loadFixture(name)
+		if err == nil {
+			fixture.write(w)
+			return
+		}
+
+		// Keep fixtures that cannot be read, which may have been edited by hand, rather than record over them
+		if !os.IsNotExist(err) {
+			fs.logFor(r).Error("Loading fixture "+name, err)
+			http.Error(w, fmt.Sprintf("Webby could not load the fixture %s: %s", name, err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		if mode == FixtureModeReplay {
+			fs.logFor(r).Debug("No fixture recorded for " + r.Method + " " + r.URL.RequestURI())
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "No fixture has been recorded for " + r.Method + " " + r.URL.RequestURI(),
+			})
+			return
+		}
+	}
+
+	// Request an unencoded body from upstream so fixtures are stored readable
+	r.Header.Del("Accept-Encoding")
+
+	recorder := &recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	proxy.ServeHTTP(recorder, r)
+
+	if recorder.status == http.StatusBadGateway {
+		return
+	}
+
+	fixture := newFixture(r, body, recorder)
+	err = fs.storeFixture(name, fixture)
+	if err != nil {
+		fs.logFor(r).Error("Recording fixture", err)
+		return
+	}
+	fs.logFor(r).Debug("Recorded fixture " + name)
+}
+
+func (fs *FileServer) fixtureFilePath(name string) (string, error) {
+	if name != filepath.Base(name) || filepath.Ext(name) != ".json" {
+		return "", errors.New("invalid fixture file name")
+	}
+	return filepath.Join(fs.FixturePath(), name), nil
+}
+
+func (fs *FileServer) loadFixture(name string) (*Fixture, error) {
+	data, err := fs.ReadFixtureFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	fixture := &Fixture{File: name}
+	err = json.Unmarshal(data, fixture)
+	return fixture, err
+}
+
+func (fs *FileServer) storeFixture(name string, fixture *Fixture) error {
+	err := os.MkdirAll(fs.FixturePath(), 0755)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(fixture, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(fs.FixturePath(), name), data, 0644)
+}
+
+// fixtureFileName builds the file name used to store the fixture matching the
+// given request, from its method, path, query and a hash of its body.
+func fixtureFileName(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n%s\n%s", r.Method, r.URL.Path, r.URL.RawQuery, hashBody(body))
+	slug := strings.Trim(fixtureNameRegex.ReplaceAllString(r.URL.Path, "-"), "-")
+	if len(slug) > 60 {
+		slug = slug[:60]
+	}
+	return fmt.Sprintf("%s-%s-%s.json", strings.ToLower(r.Method), slug, hex.EncodeToString(hash.Sum(nil))[:12])
+}
+
+func hashBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func newFixture(r *http.Request, body []byte, recorder *recordingResponseWriter) *Fixture {
+	fixture := &Fixture{
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Query:    r.URL.RawQuery,
+		BodyHash: hashBody(body),
+		Status:   recorder.status,
+		Headers:  make(http.Header),
+	}
+
+	for name, values := range recorder.Header() {
+		if !skippedFixtureHeaders[name] {
+			fixture.Headers[name] = values
+		}
+	}
+
+	responseBody := recorder.body.Bytes()
+	if utf8.Valid(responseBody) {
+		fixture.Body = string(responseBody)
+	} else {
+		fixture.Body = base64.StdEncoding.EncodeToString(responseBody)
+		fixture.Base64 = true
+	}
+
+	return fixture
+}
+
+func (f *Fixture) write(w http.ResponseWriter) {
+	for name, values := range f.Headers {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.Header().Set("X-Webby-Fixture", f.File)
+
+	body := []byte(f.Body)
+	if f.Base64 {
+		body, _ = base64.StdEncoding.DecodeString(f.Body)
+	}
+
+	status := f.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// recordingResponseWriter keeps a copy of the response status
+// and body while passing them on to the client.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingResponseWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingResponseWriter) Write(data []byte) (int, error) {
+	rw.body.Write(data)
+	return rw.ResponseWriter.Write(data)
+}
+
+// Unwrap provides the underlying response writer for use by http.ResponseController.
+func (rw *recordingResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
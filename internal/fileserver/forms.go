@@ -0,0 +1,310 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ssddanbrown/webby/internal/logger"
+)
+
+// formsDir is the folder, relative to the server root, where form submissions are stored.
+var formsDir = filepath.Join(webbyDir, "forms")
+
+// maxFormMemory is the amount of a multipart form body held in memory before using temporary files.
+const maxFormMemory = 32 << 20
+
+// maxFormSize is the largest form body, including uploaded files, captured as a submission.
+const maxFormSize = 64 << 20
+
+// FormConfig sets which POST requests are captured as form submissions.
+type FormConfig struct {
+	Paths      []string `json:"paths"`
+	CaptureAll bool     `json:"capture_all"`
+	Redirect   string   `json:"redirect,omitempty"`
+}
+
+// FormSubmission is a captured form post stored within the project.
+type FormSubmission struct {
+	ID       string              `json:"id"`
+	Path     string              `json:"path"`
+	Time     time.Time           `json:"time"`
+	ClientIP string              `json:"client_ip"`
+	Referer  string              `json:"referer,omitempty"`
+	Fields   map[string][]string `json:"fields"`
+	Files    []FormFile          `json:"files,omitempty"`
+}
+
+// FormFile is an uploaded file captured as part of a form submission.
+type FormFile struct {
+	Field       string `json:"field"`
+	Name        string `json:"name"`
+	StoredName  string `json:"stored_name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+var thankYouTemplate = template.Must(template.New("ThankYou").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width">
+	<title>Form Submitted</title>
+	<style>body { font-family: sans-serif; max-width: 640px; margin: 40px auto; padding: 0 16px; color: #333; } td { padding: 2px 12px 2px 0; vertical-align: top; }</style>
+</head>
+<body>
+	<h1>Thank you!</h1>
+	<p>Your submission was captured by webby.</p>
+	<table>
+		{{range $name, $values := .Fields}}<tr><td><strong>{{$name}}</strong></td><td>{{range $values}}{{.}}<br>{{end}}</td></tr>{{end}}
+		{{range .Files}}<tr><td><strong>{{.Field}}</strong></td><td>{{.Name}} ({{.Size}} bytes)</td></tr>{{end}}
+	</table>
+	{{if .Referer}}<p><a href="{{.Referer}}">&larr; Back</a></p>{{end}}
+</body>
+</html>
+`))
+
+// SetForms sets how form submissions are captured, or stops capturing if nil.
+func (fs *FileServer) SetForms(config *FormConfig) error {
+	if config != nil {
+		for _, path := range config.Paths {
+			if !strings.HasPrefix(path, "/") {
+				return fmt.Errorf("form path %q must start with a /", path)
+			}
+		}
+	}
+
+	fs.mutex.Lock()
+	fs.Forms = config
+	fs.mutex.Unlock()
+	return nil
+}
+
+// FormsPath provides the absolute path of the folder the server stores form submissions in.
+func (fs *FileServer) FormsPath() string {
+	return filepath.Join(fs.RootPath, formsDir)
+}
+
+// ListFormSubmissions loads all captured form submissions, newest first.
+func (fs *FileServer) ListFormSubmissions() ([]*FormSubmission, error) {
+	files, err := ioutil.ReadDir(fs.FormsPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var submissions []*FormSubmission
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		submission, err := fs.loadFormSubmission(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			logger.Error("Loading form submission "+file.Name(), err)
+			continue
+		}
+		submissions = append(submissions, submission)
+	}
+
+	sort.Slice(submissions, func(i, j int) bool {
+		return submissions[i].Time.After(submissions[j].Time)
+	})
+	return submissions, nil
+}
+
+// FormFilePath provides the path of a file uploaded as part of the given submission.
+func (fs *FileServer) FormFilePath(submissionID string, storedName string) (string, error) {
+	if !isSafeFileName(submissionID) || !isSafeFileName(storedName) {
+		return "", errors.New("invalid form file name")
+	}
+	return filepath.Join(fs.FormsPath(), submissionID, storedName), nil
+}
+
+// DeleteFormSubmission removes the given submission along with its uploaded files.
+func (fs *FileServer) DeleteFormSubmission(submissionID string) error {
+	if !isSafeFileName(submissionID) {
+		return errors.New("invalid form submission id")
+	}
+	err := os.RemoveAll(filepath.Join(fs.FormsPath(), submissionID))
+	if err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(fs.FormsPath(), submissionID+".json"))
+}
+
+// matchFormCapture checks if the given request should be captured as a form submission.
+func (fs *FileServer) matchFormCapture(r *http.Request) *FormConfig {
+	if r.Method != http.MethodPost {
+		return nil
+	}
+
+	fs.mutex.RLock()
+	config := fs.Forms
+	proxied := fs.proxy != nil
+	fs.mutex.RUnlock()
+
+	if config == nil {
+		return nil
+	}
+	for _, path := range config.Paths {
+		if r.URL.Path == path {
+			return config
+		}
+	}
+
+	// Leave posts to CGI or FastCGI scripts for the scripts to handle
+	if config.CaptureAll && !proxied {
+		if handler, _ := fs.matchScript(filepath.Join(fs.RootPath, r.URL.Path)); handler == nil {
+			return config
+		}
+	}
+	return nil
+}
+
+// serveFormCapture stores the submitted form then redirects or shows a thank you page.
+func (fs *FileServer) serveFormCapture(w http.ResponseWriter, r *http.Request, config *FormConfig) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+	submission, err := fs.captureFormSubmission(r)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Webby could not capture the form submission: larger than %d bytes", maxFormSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		logger.Error("Capturing form submission", err)
+		http.Error(w, "Webby could not capture the form submission: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger.Devlog("Captured form submission to " + submission.Path)
+	fs.sendEvent("form-submission", map[string]interface{}{
+		"server": fs.ID,
+		"id":     submission.ID,
+		"path":   submission.Path,
+	})
+
+	if config.Redirect != "" {
+		http.Redirect(w, r, config.Redirect, http.StatusSeeOther)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	thankYouTemplate.Execute(w, submission)
+}
+
+func (fs *FileServer) captureFormSubmission(r *http.Request) (*FormSubmission, error) {
+	contentType := r.Header.Get("Content-Type")
+	var err error
+	if strings.HasPrefix(contentType, "multipart/form-data") {
+		err = r.ParseMultipartForm(maxFormMemory)
+		// Remove temporary files of large uploads once they have been stored
+		if r.MultipartForm != nil {
+			defer r.MultipartForm.RemoveAll()
+		}
+	} else {
+		err = r.ParseForm()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	submission := &FormSubmission{
+		ID:       fmt.Sprintf("%s-%09d", now.Format("20060102-150405"), now.Nanosecond()),
+		Path:     r.URL.Path,
+		Time:     now,
+		Referer:  r.Referer(),
+		Fields:   r.PostForm,
+		ClientIP: r.RemoteAddr,
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		submission.ClientIP = host
+	}
+
+	err = os.MkdirAll(fs.FormsPath(), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.MultipartForm != nil {
+		for field, headers := range r.MultipartForm.File {
+			for index, header := range headers {
+				file := FormFile{
+					Field:       field,
+					Name:        header.Filename,
+					StoredName:  fmt.Sprintf("%s-%d%s", sanitiseFileName(field), index, filepath.Ext(filepath.Base(header.Filename))),
+					ContentType: header.Header.Get("Content-Type"),
+					Size:        header.Size,
+				}
+				err = fs.storeFormFile(submission.ID, file.StoredName, header.Open)
+				if err != nil {
+					return nil, err
+				}
+				submission.Files = append(submission.Files, file)
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(submission, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(filepath.Join(fs.FormsPath(), submission.ID+".json"), data, 0644)
+	return submission, err
+}
+
+func (fs *FileServer) storeFormFile(submissionID string, storedName string, open func() (multipart.File, error)) error {
+	source, err := open()
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	dir := filepath.Join(fs.FormsPath(), submissionID)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	target, err := os.Create(filepath.Join(dir, storedName))
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	_, err = io.Copy(target, source)
+	return err
+}
+
+func (fs *FileServer) loadFormSubmission(id string) (*FormSubmission, error) {
+	data, err := ioutil.ReadFile(filepath.Join(fs.FormsPath(), id+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	submission := new(FormSubmission)
+	err = json.Unmarshal(data, submission)
+	return submission, err
+}
+
+// isSafeFileName checks the given name can be used as a single path segment.
+func isSafeFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && name == filepath.Base(name) && !strings.ContainsAny(name, `/\`)
+}
+
+func sanitiseFileName(name string) string {
+	return strings.Trim(fixtureNameRegex.ReplaceAllString(name, "-"), "-")
+}
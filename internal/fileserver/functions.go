@@ -0,0 +1,377 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/ssddanbrown/webby/internal/logger"
+)
+
+// functionTimeout is the maximum time a function may run before being interrupted.
+const functionTimeout = 5 * time.Second
+
+// FunctionsConfig runs JavaScript files within a folder of the served root
+// as serverless-style functions under a URL prefix.
+type FunctionsConfig struct {
+	Dir    string `json:"dir"`
+	Prefix string `json:"prefix"`
+}
+
+// compiledFunction is a function script compiled at a specific file modification time.
+type compiledFunction struct {
+	program *goja.Program
+	modTime time.Time
+}
+
+// functionCache holds compiled function scripts so they are only recompiled on change.
+var functionCache = struct {
+	sync.Mutex
+	programs map[string]*compiledFunction
+}{programs: make(map[string]*compiledFunction)}
+
+var functionErrorTemplate = template.Must(template.New("FunctionError").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<title>Function Error</title>
+	<style>body { font-family: sans-serif; max-width: 800px; margin: 40px auto; padding: 0 16px; color: #333; } pre { background: #FDECEC; color: #A22; padding: 16px; overflow: auto; }</style>
+</head>
+<body>
+	<h1>Function error in {{.File}}</h1>
+	<pre>{{.Error}}</pre>
+</body>
+</html>
+`))
+
+// SetFunctions enables serverless functions using the given config, or disables them if nil.
+func (fs *FileServer) SetFunctions(config *FunctionsConfig) error {
+	if config != nil {
+		if config.Dir == "" {
+			config.Dir = "functions"
+		}
+		if config.Prefix == "" {
+			config.Prefix = "/api"
+		}
+		if !strings.HasPrefix(config.Prefix, "/") {
+			return fmt.Errorf("functions prefix %q must start with a /", config.Prefix)
+		}
+		if _, err := fs.functionsPath(config); err != nil {
+			return err
+		}
+	}
+
+	fs.mutex.Lock()
+	fs.Functions = config
+	fs.mutex.Unlock()
+	return nil
+}
+
+// matchFunction finds the function script that should handle the given request path.
+func (fs *FileServer) matchFunction(path string) (string, bool) {
+	fs.mutex.RLock()
+	config := fs.Functions
+	fs.mutex.RUnlock()
+
+	if config == nil {
+		return "", false
+	}
+
+	prefix := strings.TrimSuffix(config.Prefix, "/") + "/"
+	if !strings.HasPrefix(path, prefix) {
+		return "", false
+	}
+
+	name := strings.SplitN(strings.TrimPrefix(path, prefix), "/", 2)[0]
+	if !isSafeFileName(name) {
+		return "", false
+	}
+
+	dir, err := fs.functionsPath(config)
+	if err != nil {
+		return "", false
+	}
+
+	fPath := filepath.Join(dir, name+".js")
+	if stat, err := os.Stat(fPath); err != nil || stat.IsDir() {
+		return "", false
+	}
+	return fPath, true
+}
+
+func (fs *FileServer) functionsPath(config *FunctionsConfig) (string, error) {
+	dir := filepath.Join(fs.RootPath, config.Dir)
+	if dir != fs.RootPath && !strings.HasPrefix(dir, fs.RootPath+string(filepath.Separator)) {
+		return "", fmt.Errorf("functions folder %q must be within the served folder", config.Dir)
+	}
+	return dir, nil
+}
+
+// isFunctionSource checks if the given file is a function script, which is run rather than served.
+// Functions in the served folder itself only hide its JavaScript files.
+func (fs *FileServer) isFunctionSource(fPath string) bool {
+	fs.mutex.RLock()
+	config := fs.Functions
+	fs.mutex.RUnlock()
+
+	if config == nil {
+		return false
+	}
+	dir, err := fs.functionsPath(config)
+	if err != nil {
+		return false
+	}
+
+	if dir == fs.RootPath {
+		return filepath.Dir(fPath) == dir && strings.EqualFold(filepath.Ext(fPath), ".js")
+	}
+	relative, err := filepath.Rel(strings.ToLower(dir), strings.ToLower(fPath))
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// serveFunction runs the given function script for the request and writes its response.
+func (fs *FileServer) serveFunction(w http.ResponseWriter, r *http.Request, fPath string) {
+	w.Header().Set("Cache-Control", "no-cache")
+
+	err := runFunction(w, r, fPath)
+	if err == nil {
+		return
+	}
+
+	relPath, _ := filepath.Rel(fs.RootPath, fPath)
+	fs.logFor(r).Error("Running function "+relPath, err)
+	fs.sendEvent("function-error", map[string]string{
+		"file":  relPath,
+		"error": err.Error(),
+	})
+
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		functionErrorTemplate.Execute(w, map[string]string{"File": relPath, "Error": err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error(), "file": relPath})
+}
+
+// runFunction executes the handler exported by the given script within a new,
+// sandboxed, JavaScript runtime then writes the returned response.
+func runFunction(w http.ResponseWriter, r *http.Request, fPath string) error {
+	program, err := loadFunction(fPath)
+	if err != nil {
+		return err
+	}
+
+	vm := goja.New()
+	vm.SetFieldNameMapper(goja.TagFieldNameMapper("json", true))
+	timer := time.AfterFunc(functionTimeout, func() {
+		vm.Interrupt(fmt.Sprintf("function exceeded the %s time limit", functionTimeout))
+	})
+	defer timer.Stop()
+
+	module := vm.NewObject()
+	exports := vm.NewObject()
+	module.Set("exports", exports)
+	vm.Set("module", module)
+	vm.Set("exports", exports)
+	vm.Set("console", newFunctionConsole(vm, filepath.Base(fPath)))
+
+	if _, err := vm.RunProgram(program); err != nil {
+		return err
+	}
+
+	handler, err := findFunctionHandler(vm, module)
+	if err != nil {
+		return err
+	}
+
+	request, err := newFunctionRequest(vm, r)
+	if err != nil {
+		return err
+	}
+
+	result, err := handler(goja.Undefined(), request)
+	if err != nil {
+		return err
+	}
+
+	if promise, ok := result.Export().(*goja.Promise); ok {
+		switch promise.State() {
+		case goja.PromiseStateFulfilled:
+			result = promise.Result()
+		case goja.PromiseStateRejected:
+			return fmt.Errorf("function promise rejected: %s", promise.Result().String())
+		default:
+			return errors.New("function promise did not resolve, timers and other async APIs are not available")
+		}
+	}
+
+	return writeFunctionResponse(w, vm, result)
+}
+
+func loadFunction(fPath string) (*goja.Program, error) {
+	stat, err := os.Stat(fPath)
+	if err != nil {
+		return nil, err
+	}
+
+	functionCache.Lock()
+	defer functionCache.Unlock()
+
+	cached, exists := functionCache.programs[fPath]
+	if exists && cached.modTime.Equal(stat.ModTime()) {
+		return cached.program, nil
+	}
+
+	source, err := ioutil.ReadFile(fPath)
+	if err != nil {
+		return nil, err
+	}
+
+	program, err := goja.Compile(filepath.Base(fPath), string(source), false)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Devlog("Compiled function " + fPath)
+	functionCache.programs[fPath] = &compiledFunction{program: program, modTime: stat.ModTime()}
+	return program, nil
+}
+
+// findFunctionHandler finds the handler function exported by a script, supporting
+// module.exports, exports.handler and exports.default.
+func findFunctionHandler(vm *goja.Runtime, module *goja.Object) (goja.Callable, error) {
+	exported := module.Get("exports")
+	if handler, ok := goja.AssertFunction(exported); ok {
+		return handler, nil
+	}
+
+	if object := exported.ToObject(vm); object != nil {
+		for _, name := range []string{"handler", "default"} {
+			if handler, ok := goja.AssertFunction(object.Get(name)); ok {
+				return handler, nil
+			}
+		}
+	}
+
+	return nil, errors.New("function script must export a handler function via module.exports or exports.handler")
+}
+
+func newFunctionRequest(vm *goja.Runtime, r *http.Request) (goja.Value, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string]interface{})
+	for name, values := range r.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ", ")
+	}
+
+	query := make(map[string]interface{})
+	for name, values := range r.URL.Query() {
+		query[name] = values[0]
+	}
+
+	request := vm.NewObject()
+	request.Set("method", r.Method)
+	request.Set("url", r.URL.String())
+	request.Set("path", r.URL.Path)
+	request.Set("query", query)
+	request.Set("headers", headers)
+	request.Set("body", string(body))
+	request.Set("json", func() (interface{}, error) {
+		var data interface{}
+		err := json.Unmarshal(body, &data)
+		return data, err
+	})
+	return request, nil
+}
+
+// writeFunctionResponse writes the value returned by a function to the response.
+// Strings are sent as-is while objects can set the status, headers and body.
+func writeFunctionResponse(w http.ResponseWriter, vm *goja.Runtime, result goja.Value) error {
+	if goja.IsUndefined(result) || goja.IsNull(result) {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	if text, ok := result.Export().(string); ok {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(text))
+		return nil
+	}
+
+	response := result.ToObject(vm)
+	status := http.StatusOK
+	for _, name := range []string{"status", "statusCode"} {
+		if value := response.Get(name); value != nil && !goja.IsUndefined(value) {
+			status = int(value.ToInteger())
+		}
+	}
+	if !isValidStatus(status) {
+		return fmt.Errorf("function returned invalid status %d, expected 200 to 599", status)
+	}
+
+	if headers := response.Get("headers"); headers != nil && !goja.IsUndefined(headers) {
+		headerObject := headers.ToObject(vm)
+		for _, name := range headerObject.Keys() {
+			w.Header().Set(name, headerObject.Get(name).String())
+		}
+	}
+
+	var body []byte
+	bodyValue := response.Get("body")
+	if bodyValue != nil && !goja.IsUndefined(bodyValue) && !goja.IsNull(bodyValue) {
+		if text, ok := bodyValue.Export().(string); ok {
+			body = []byte(text)
+		} else {
+			data, err := json.Marshal(bodyValue.Export())
+			if err != nil {
+				return err
+			}
+			body = data
+			if w.Header().Get("Content-Type") == "" {
+				w.Header().Set("Content-Type", "application/json")
+			}
+		}
+	}
+
+	w.WriteHeader(status)
+	w.Write(body)
+	return nil
+}
+
+// isValidStatus checks the given status code can be written as a final response.
+// Informational 1xx statuses are not, since they are followed by a 200 response.
+func isValidStatus(status int) bool {
+	return status >= 200 && status <= 599
+}
+
+// newFunctionConsole provides a console object that writes to the webby log.
+func newFunctionConsole(vm *goja.Runtime, name string) *goja.Object {
+	console := vm.NewObject()
+	logFunc := func(call goja.FunctionCall) goja.Value {
+		var parts []string
+		for _, arg := range call.Arguments {
+			parts = append(parts, arg.String())
+		}
+		logger.Devlog("[" + name + "] " + strings.Join(parts, " "))
+		return goja.Undefined()
+	}
+	for _, method := range []string{"log", "info", "warn", "error", "debug"} {
+		console.Set(method, logFunc)
+	}
+	return console
+}
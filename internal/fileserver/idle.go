@@ -0,0 +1,29 @@
+package fileserver
+
+import "time"
+
+// SetIdleTimeout sets how many minutes, without requests or connected clients,
+// the server is left running for before being closed. Zero keeps it running.
+func (fs *FileServer) SetIdleTimeout(minutes int) {
+	fs.mutex.Lock()
+	fs.IdleTimeout = minutes
+	fs.mutex.Unlock()
+}
+
+// IsIdle checks if, at the given time, the server has had no requests or
+// connected live reload clients for longer than its idle timeout.
+func (fs *FileServer) IsIdle(now time.Time) bool {
+	fs.mutex.RLock()
+	timeout := time.Duration(fs.IdleTimeout) * time.Minute
+	fs.mutex.RUnlock()
+	if timeout <= 0 {
+		return false
+	}
+
+	fs.stats.Lock()
+	defer fs.stats.Unlock()
+	if fs.stats.ActiveRequests > 0 || fs.stats.LiveReloadClients > 0 {
+		return false
+	}
+	return now.Sub(fs.stats.lastActivity) >= timeout
+}
@@ -0,0 +1,281 @@
+package fileserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ssddanbrown/webby/internal/logger"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// imageCacheDir is the folder, relative to the server root, where generated image variants are stored.
+var imageCacheDir = filepath.Join(webbyDir, "images")
+
+// maxImageSize is the largest width or height an image variant can be generated at.
+const maxImageSize = 4000
+
+// maxSourceImagePixels is the largest number of pixels an image can have for variants to be generated from it.
+const maxSourceImagePixels = 50000000
+
+// resizableImageExts lists the image file extensions that variants can be generated from.
+var resizableImageExts = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+	".webp": true,
+}
+
+// modernImageFormats lists the sibling formats, in order of preference, served to browsers that accept them.
+var modernImageFormats = []struct {
+	ext       string
+	mediaType string
+}{
+	{".avif", "image/avif"},
+	{".webp", "image/webp"},
+}
+
+// imageVariant holds the options for generating a variant of an image.
+type imageVariant struct {
+	width   int
+	height  int
+	fit     string
+	quality int
+}
+
+// SetModernImages sets if .avif and .webp siblings of images are served to browsers that accept them.
+func (fs *FileServer) SetModernImages(enabled bool) {
+	fs.mutex.Lock()
+	fs.ModernImages = enabled
+	fs.mutex.Unlock()
+}
+
+// ToggleModernImages switches serving modern image formats, returning if it is now enabled.
+func (fs *FileServer) ToggleModernImages() bool {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.ModernImages = !fs.ModernImages
+	return fs.ModernImages
+}
+
+func (fs *FileServer) modernImages() bool {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+	return fs.ModernImages
+}
+
+// isResizableImage checks if the given path is an image that variants can be generated from.
+func isResizableImage(fPath string) bool {
+	return resizableImageExts[strings.ToLower(filepath.Ext(fPath))]
+}
+
+// parseImageVariant reads the variant options from the query parameters of the given request.
+// A nil variant is returned if no resizing options were given.
+func parseImageVariant(r *http.Request) (*imageVariant, error) {
+	query := r.URL.Query()
+	if query.Get("w") == "" && query.Get("h") == "" {
+		return nil, nil
+	}
+
+	variant := &imageVariant{fit: query.Get("fit"), quality: 80}
+	for name, target := range map[string]*int{"w": &variant.width, "h": &variant.height, "q": &variant.quality} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 || number > maxImageSize {
+			return nil, fmt.Errorf("image parameter %s must be a number between 1 and %d", name, maxImageSize)
+		}
+		*target = number
+	}
+
+	if variant.quality > 100 {
+		return nil, errors.New("image parameter q must be between 1 and 100")
+	}
+
+	switch variant.fit {
+	case "":
+		variant.fit = "contain"
+	case "contain", "cover", "fill":
+	default:
+		return nil, fmt.Errorf("unknown image fit %q, expected contain, cover or fill", variant.fit)
+	}
+
+	return variant, nil
+}
+
+// serveImageVariant serves a resized version of the given image, generating
+// and caching it on disk if not already generated.
+func (fs *FileServer) serveImageVariant(w http.ResponseWriter, r *http.Request, fPath string, variant *imageVariant) {
+	stat, err := os.Stat(fPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%d\n%d\n%d\n%s\n%d", fPath, stat.ModTime().UnixNano(), variant.width, variant.height, variant.fit, variant.quality)
+	ext := variantExt(fPath)
+	cachePath := filepath.Join(fs.RootPath, imageCacheDir, hex.EncodeToString(hash.Sum(nil))[:24]+ext)
+
+	if _, err := os.Stat(cachePath); err != nil {
+		err = generateImageVariant(fPath, cachePath, variant)
+		if err != nil {
+			fs.logFor(r).Error("Generating image variant of "+fPath, err)
+			http.Error(w, "Webby could not generate the image variant: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logger.Devlog(fmt.Sprintf("Generated %dx%d variant of %s", variant.width, variant.height, fPath))
+	}
+
+	http.ServeFile(w, r, cachePath)
+}
+
+// variantExt provides the file extension variants of the given image are generated in,
+// using PNG for formats that cannot be encoded.
+func variantExt(fPath string) string {
+	ext := strings.ToLower(filepath.Ext(fPath))
+	if ext == ".jpeg" || ext == ".jpg" {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+func generateImageVariant(fPath string, cachePath string, variant *imageVariant) error {
+	file, err := os.Open(fPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Check the size before decoding, since decoding allocates memory for every pixel
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return err
+	}
+	if config.Width*config.Height > maxSourceImagePixels {
+		return fmt.Errorf("image is too large to resize at %dx%d", config.Width, config.Height)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	source, _, err := image.Decode(file)
+	if err != nil {
+		return err
+	}
+
+	resized := resizeImage(source, variant)
+
+	err = os.MkdirAll(filepath.Dir(cachePath), 0755)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so partially written variants are never served
+	output, err := os.CreateTemp(filepath.Dir(cachePath), ".variant-*")
+	if err != nil {
+		return err
+	}
+	if filepath.Ext(cachePath) == ".jpg" {
+		err = jpeg.Encode(output, resized, &jpeg.Options{Quality: variant.quality})
+	} else {
+		err = png.Encode(output, resized)
+	}
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(output.Name(), cachePath)
+	}
+	if err != nil {
+		os.Remove(output.Name())
+	}
+	return err
+}
+
+// resizeImage scales the given image to the variant size. Contain keeps the aspect
+// ratio within the size, cover crops to fill the size and fill stretches to the size.
+func resizeImage(source image.Image, variant *imageVariant) image.Image {
+	bounds := source.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	width, height := variant.width, variant.height
+
+	// Keep the aspect ratio when only one dimension is given
+	if width == 0 {
+		width = atLeastOne(srcWidth * height / srcHeight)
+	}
+	if height == 0 {
+		height = atLeastOne(srcHeight * width / srcWidth)
+	}
+
+	srcRect := bounds
+	switch {
+	case variant.width == 0 || variant.height == 0 || variant.fit == "fill":
+	case variant.fit == "cover":
+		// Crop the source, around its center, to the target aspect ratio
+		if srcWidth*height > srcHeight*width {
+			cropWidth := srcHeight * width / height
+			offset := (srcWidth - cropWidth) / 2
+			srcRect = image.Rect(bounds.Min.X+offset, bounds.Min.Y, bounds.Min.X+offset+cropWidth, bounds.Max.Y)
+		} else {
+			cropHeight := srcWidth * height / width
+			offset := (srcHeight - cropHeight) / 2
+			srcRect = image.Rect(bounds.Min.X, bounds.Min.Y+offset, bounds.Max.X, bounds.Min.Y+offset+cropHeight)
+		}
+	default:
+		if srcWidth*height > srcHeight*width {
+			height = atLeastOne(srcHeight * width / srcWidth)
+		} else {
+			width = atLeastOne(srcWidth * height / srcHeight)
+		}
+	}
+
+	target := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(target, target.Bounds(), source, srcRect, draw.Over, nil)
+	return target
+}
+
+// serveModernImage serves an .avif or .webp sibling of the given image if the browser
+// accepts that format, returning false if no acceptable sibling exists.
+func (fs *FileServer) serveModernImage(w http.ResponseWriter, r *http.Request, fPath string) bool {
+	w.Header().Add("Vary", "Accept")
+
+	accept := r.Header.Get("Accept")
+	base := strings.TrimSuffix(fPath, filepath.Ext(fPath))
+	for _, format := range modernImageFormats {
+		if !strings.Contains(accept, format.mediaType) || strings.EqualFold(filepath.Ext(fPath), format.ext) {
+			continue
+		}
+
+		siblingPath := base + format.ext
+		if stat, err := os.Stat(siblingPath); err == nil && !stat.IsDir() {
+			w.Header().Set("Content-Type", format.mediaType)
+			http.ServeFile(w, r, siblingPath)
+			return true
+		}
+	}
+	return false
+}
+
+// atLeastOne keeps scaled image dimensions from rounding down to zero.
+func atLeastOne(size int) int {
+	if size < 1 {
+		return 1
+	}
+	return size
+}
@@ -0,0 +1,263 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ssddanbrown/webby/internal/logger"
+)
+
+// exportConditions are the package export conditions used when resolving modules for the browser, in order of preference.
+var exportConditions = []string{"browser", "import", "module", "default"}
+
+// importMapRegex matches an existing import map script within a HTML page.
+var importMapRegex = regexp.MustCompile(`(?is)(<script[^>]*type=["']?importmap["']?[^>]*>)(.*?)(</script>)`)
+
+// headOpenRegex matches the opening head tag of a HTML page.
+var headOpenRegex = regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
+
+// importMap is the structure of a browser import map.
+type importMap struct {
+	Imports map[string]string            `json:"imports"`
+	Scopes  map[string]map[string]string `json:"scopes,omitempty"`
+}
+
+// packageManifest holds the package.json fields used to resolve a module entry point.
+type packageManifest struct {
+	Exports interface{} `json:"exports"`
+	Module  string      `json:"module"`
+	Browser interface{} `json:"browser"`
+	Main    string      `json:"main"`
+}
+
+// cachedImportMap is a generated set of imports along with the package modification time it was generated at.
+type cachedImportMap struct {
+	imports map[string]string
+	modTime time.Time
+}
+
+// importMapCache holds generated imports, by root path, so node_modules is only scanned on change.
+var importMapCache = struct {
+	sync.Mutex
+	maps map[string]*cachedImportMap
+}{maps: make(map[string]*cachedImportMap)}
+
+// injectImportMap adds imports for the packages in the served node_modules folder to the
+// given HTML, merged into any existing import map with existing entries taking priority.
+func (fs *FileServer) injectImportMap(html []byte) []byte {
+	imports := fs.nodeModuleImports()
+	if len(imports) == 0 {
+		return html
+	}
+
+	if match := importMapRegex.FindSubmatchIndex(html); match != nil {
+		// Only the imports are changed, other keys such as scopes and integrity are kept as they are
+		existing := make(map[string]json.RawMessage)
+		existingImports := make(map[string]string)
+		err := json.Unmarshal(html[match[4]:match[5]], &existing)
+		if err == nil && existing["imports"] != nil {
+			err = json.Unmarshal(existing["imports"], &existingImports)
+		}
+		if err != nil {
+			logger.Error("Parsing existing import map", err)
+			return html
+		}
+		for specifier, url := range imports {
+			if _, exists := existingImports[specifier]; !exists {
+				existingImports[specifier] = url
+			}
+		}
+		existing["imports"], _ = json.Marshal(existingImports)
+
+		mapJSON, _ := json.MarshalIndent(existing, "", "    ")
+		result := make([]byte, 0, len(html)+len(mapJSON))
+		result = append(result, html[:match[3]]...)
+		result = append(result, mapJSON...)
+		return append(result, html[match[6]:]...)
+	}
+
+	mapJSON, _ := json.MarshalIndent(importMap{Imports: imports}, "", "    ")
+	script := "\n<script type=\"importmap\">" + string(mapJSON) + "</script>\n"
+
+	// Import maps must come before any module scripts so are placed at the start of the head
+	index := 0
+	if match := headOpenRegex.FindIndex(html); match != nil {
+		index = match[1]
+	} else if scriptIndex := strings.Index(strings.ToLower(string(html)), "<script"); scriptIndex != -1 {
+		index = scriptIndex
+	}
+
+	result := make([]byte, 0, len(html)+len(script))
+	result = append(result, html[:index]...)
+	result = append(result, script...)
+	return append(result, html[index:]...)
+}
+
+// nodeModuleImports provides the import map entries for the packages in the served node_modules folder.
+func (fs *FileServer) nodeModuleImports() map[string]string {
+	modulesPath := filepath.Join(fs.RootPath, "node_modules")
+	stat, err := os.Stat(modulesPath)
+	if err != nil || !stat.IsDir() {
+		return nil
+	}
+
+	importMapCache.Lock()
+	defer importMapCache.Unlock()
+
+	modTime := packagesModTime(fs.RootPath, modulesPath)
+	cached, exists := importMapCache.maps[fs.RootPath]
+	if exists && cached.modTime.Equal(modTime) {
+		return cached.imports
+	}
+
+	imports := make(map[string]string)
+	for _, name := range listPackages(modulesPath) {
+		addPackageImports(imports, modulesPath, name)
+	}
+
+	logger.Devlog("Generated import map from " + modulesPath)
+	importMapCache.maps[fs.RootPath] = &cachedImportMap{imports: imports, modTime: modTime}
+	return imports
+}
+
+// packagesModTime finds the latest change to the installed packages. The node_modules folder
+// alone misses packages upgraded in place, or installed within a scope folder, so the
+// package manifest and lock files, along with the scope folders, are also checked.
+func packagesModTime(rootPath string, modulesPath string) time.Time {
+	paths := []string{
+		modulesPath,
+		filepath.Join(modulesPath, ".package-lock.json"),
+		filepath.Join(rootPath, "package.json"),
+		filepath.Join(rootPath, "package-lock.json"),
+	}
+	if files, err := ioutil.ReadDir(modulesPath); err == nil {
+		for _, file := range files {
+			if file.IsDir() && strings.HasPrefix(file.Name(), "@") {
+				paths = append(paths, filepath.Join(modulesPath, file.Name()))
+			}
+		}
+	}
+
+	var latest time.Time
+	for _, fPath := range paths {
+		if stat, err := os.Stat(fPath); err == nil && stat.ModTime().After(latest) {
+			latest = stat.ModTime()
+		}
+	}
+	return latest
+}
+
+// listPackages finds the names of the packages, including scoped packages, in the given node_modules folder.
+func listPackages(modulesPath string) []string {
+	files, err := ioutil.ReadDir(modulesPath)
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasPrefix(name, ".") || !file.IsDir() {
+			continue
+		}
+		if !strings.HasPrefix(name, "@") {
+			names = append(names, name)
+			continue
+		}
+
+		scoped, err := ioutil.ReadDir(filepath.Join(modulesPath, name))
+		if err != nil {
+			continue
+		}
+		for _, file := range scoped {
+			if file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
+				names = append(names, name+"/"+file.Name())
+			}
+		}
+	}
+	return names
+}
+
+// addPackageImports adds the import map entries for the given package, resolving
+// its entry point, and any exported subpaths, from its package.json file.
+func addPackageImports(imports map[string]string, modulesPath string, name string) {
+	data, err := ioutil.ReadFile(filepath.Join(modulesPath, filepath.FromSlash(name), "package.json"))
+	if err != nil {
+		return
+	}
+
+	manifest := packageManifest{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		logger.Devlog("Skipping package " + name + " with invalid package.json")
+		return
+	}
+
+	baseURL := "/node_modules/" + name + "/"
+	imports[name+"/"] = baseURL
+
+	exports, isSubpathMap := manifest.Exports.(map[string]interface{})
+	if isSubpathMap {
+		for key := range exports {
+			if !strings.HasPrefix(key, ".") {
+				isSubpathMap = false
+				break
+			}
+		}
+	}
+
+	if isSubpathMap {
+		for subpath, value := range exports {
+			target := resolveExport(value)
+			if target == "" || strings.Contains(subpath, "*") {
+				continue
+			}
+			imports[path.Join(name, subpath)] = baseURL + strings.TrimPrefix(path.Clean(target), "./")
+		}
+		return
+	}
+
+	entry := resolveExport(manifest.Exports)
+	if entry == "" {
+		entry = manifest.Module
+	}
+	if browser, ok := manifest.Browser.(string); ok && entry == "" {
+		entry = browser
+	}
+	if entry == "" {
+		entry = manifest.Main
+	}
+	if entry == "" {
+		entry = "index.js"
+	}
+	imports[name] = baseURL + strings.TrimPrefix(path.Clean(entry), "./")
+}
+
+// resolveExport resolves a package.json export value to a file path using the browser export conditions.
+func resolveExport(value interface{}) string {
+	switch export := value.(type) {
+	case string:
+		return export
+	case []interface{}:
+		for _, item := range export {
+			if target := resolveExport(item); target != "" {
+				return target
+			}
+		}
+	case map[string]interface{}:
+		for _, condition := range exportConditions {
+			if conditional, exists := export[condition]; exists {
+				if target := resolveExport(conditional); target != "" {
+					return target
+				}
+			}
+		}
+	}
+	return ""
+}
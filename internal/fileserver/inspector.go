@@ -0,0 +1,149 @@
+package fileserver
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxRequestRecords is how many of the latest requests are kept for the inspector.
+const maxRequestRecords = 500
+
+// RequestRecord holds the metadata of a request made to a server, and its response.
+type RequestRecord struct {
+	ID              int64       `json:"id"`
+	Started         time.Time   `json:"started"`
+	Duration        float64     `json:"duration_ms"`
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	Path            string      `json:"path"`
+	Proto           string      `json:"proto"`
+	Status          int         `json:"status"`
+	Size            int64       `json:"size"`
+	RequestSize     int64       `json:"request_size"`
+	ClientIP        string      `json:"client_ip"`
+	UserAgent       string      `json:"user_agent"`
+	RequestHeaders  http.Header `json:"request_headers"`
+	ResponseHeaders http.Header `json:"response_headers"`
+}
+
+// requestLog is a ring buffer of the latest requests made to a server.
+type requestLog struct {
+	mutex   sync.Mutex
+	records []*RequestRecord
+	next    int
+	lastID  int64
+}
+
+// add stores the given record, replacing the oldest record once the log is full.
+func (rl *requestLog) add(record *RequestRecord) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	rl.lastID++
+	record.ID = rl.lastID
+	if len(rl.records) < maxRequestRecords {
+		rl.records = append(rl.records, record)
+		return
+	}
+	rl.records[rl.next] = record
+	rl.next = (rl.next + 1) % maxRequestRecords
+}
+
+// RequestLog provides the recorded requests with an ID above the given ID, oldest first.
+func (fs *FileServer) RequestLog(since int64) []*RequestRecord {
+	fs.requests.mutex.Lock()
+	defer fs.requests.mutex.Unlock()
+
+	var records []*RequestRecord
+	count := len(fs.requests.records)
+	for i := 0; i < count; i++ {
+		record := fs.requests.records[(fs.requests.next+i)%count]
+		if record.ID > since {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// ClearRequestLog removes all recorded requests.
+func (fs *FileServer) ClearRequestLog() {
+	fs.requests.mutex.Lock()
+	fs.requests.records = nil
+	fs.requests.next = 0
+	fs.requests.mutex.Unlock()
+}
+
+// recordRequest stores the metadata of a served request in the request log.
+func (fs *FileServer) recordRequest(r *http.Request, iw *inspectResponseWriter, started time.Time) {
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+
+	// Handlers that write nothing are sent as 200 once they return, unless the client has gone,
+	// leaving the status as 0 to show the request as aborted
+	status := iw.status
+	if status == 0 && r.Context().Err() == nil {
+		status = http.StatusOK
+	}
+
+	url := fs.Scheme() + "://" + r.Host + r.URL.RequestURI()
+	record := &RequestRecord{
+		Started:         started,
+		Duration:        float64(time.Since(started).Microseconds()) / 1000,
+		Method:          r.Method,
+		URL:             url,
+		Path:            r.URL.Path,
+		Proto:           r.Proto,
+		Status:          status,
+		Size:            iw.size,
+		RequestSize:     r.ContentLength,
+		ClientIP:        clientIP,
+		UserAgent:       r.UserAgent(),
+		RequestHeaders:  r.Header.Clone(),
+		ResponseHeaders: iw.Header().Clone(),
+	}
+	fs.requests.add(record)
+	fs.countRequest(record)
+	fs.logAccess(r, record)
+}
+
+// inspectResponseWriter keeps the status and body size of a response for the request log.
+type inspectResponseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (iw *inspectResponseWriter) WriteHeader(status int) {
+	if iw.status == 0 {
+		iw.status = status
+	}
+	iw.ResponseWriter.WriteHeader(status)
+}
+
+func (iw *inspectResponseWriter) Write(data []byte) (int, error) {
+	if iw.status == 0 {
+		iw.status = http.StatusOK
+	}
+	n, err := iw.ResponseWriter.Write(data)
+	iw.size += int64(n)
+	return n, err
+}
+
+// Hijack passes through to the underlying connection so WebSocket endpoints can be recorded.
+func (iw *inspectResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buffer, err := http.NewResponseController(iw.ResponseWriter).Hijack()
+	if err == nil {
+		iw.status = http.StatusSwitchingProtocols
+	}
+	return conn, buffer, err
+}
+
+// Unwrap provides the underlying response writer for use by http.ResponseController.
+func (iw *inspectResponseWriter) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
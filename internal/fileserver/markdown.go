@@ -0,0 +1,152 @@
+package fileserver
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+)
+
+var markdown = goldmark.New(
+	goldmark.WithExtensions(
+		extension.GFM,
+		highlighting.NewHighlighting(
+			highlighting.WithStyle("github"),
+			highlighting.WithFormatOptions(chromahtml.TabWidth(4)),
+		),
+	),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+	goldmark.WithRendererOptions(gmhtml.WithUnsafe()),
+)
+
+var markdownTemplate = template.Must(template.New("Markdown").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width">
+	<title>{{.Title}}</title>
+	<style>
+		body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; line-height: 1.6; max-width: 860px; margin: 0 auto; padding: 32px 16px; color: #24292E; }
+		a { color: #0366D6; }
+		h1, h2 { border-bottom: 1px solid #EAECEF; padding-bottom: .3em; }
+		h1 a.anchor, h2 a.anchor, h3 a.anchor, h4 a.anchor, h5 a.anchor, h6 a.anchor { visibility: hidden; text-decoration: none; margin-left: 6px; }
+		h1:hover a.anchor, h2:hover a.anchor, h3:hover a.anchor, h4:hover a.anchor, h5:hover a.anchor, h6:hover a.anchor { visibility: visible; }
+		code { background: #F3F4F6; padding: .2em .4em; border-radius: 3px; font-size: 85%; }
+		pre { padding: 16px; overflow: auto; border-radius: 4px; background: #F6F8FA; }
+		pre code { background: none; padding: 0; font-size: 85%; }
+		table { border-collapse: collapse; }
+		th, td { border: 1px solid #DFE2E5; padding: 6px 13px; }
+		tr:nth-child(2n) { background: #F6F8FA; }
+		blockquote { margin: 0; padding: 0 1em; color: #6A737D; border-left: .25em solid #DFE2E5; }
+		li input[type="checkbox"] { margin-right: 6px; }
+		img { max-width: 100%; }
+		.raw-link { float: right; font-size: 13px; color: #6A737D; }
+	</style>
+</head>
+<body>
+	<a class="raw-link" href="?raw">View raw</a>
+	{{.Content}}
+	<script>
+		document.querySelectorAll('h1[id], h2[id], h3[id], h4[id], h5[id], h6[id]').forEach(function(heading) {
+			var anchor = document.createElement('a');
+			anchor.className = 'anchor';
+			anchor.href = '#' + heading.id;
+			anchor.textContent = '#';
+			heading.appendChild(anchor);
+		});
+	</script>
+</body>
+</html>
+`))
+
+// serveMarkdown renders the given markdown file as a HTML page, with the live reload
+// script if enabled, or serves the original file if the raw query parameter is set.
+func (fs *FileServer) serveMarkdown(w http.ResponseWriter, r *http.Request, fPath string) {
+	if _, raw := r.URL.Query()["raw"]; raw {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		http.ServeFile(w, r, fPath)
+		return
+	}
+
+	content, err := renderMarkdown(fPath)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err != nil {
+		relPath, _ := filepath.Rel(fs.RootPath, fPath)
+		fs.logFor(r).Error("Rendering "+relPath, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		content = renderPageError(relPath, err)
+	}
+
+	w.Write(fs.injectLiveReload(content, r))
+}
+
+func renderMarkdown(fPath string) ([]byte, error) {
+	source, err := os.ReadFile(fPath)
+	if err != nil {
+		return nil, err
+	}
+
+	document := markdown.Parser().Parse(text.NewReader(source))
+	var body bytes.Buffer
+	if err := markdown.Renderer().Render(&body, source, document); err != nil {
+		return nil, err
+	}
+
+	title := markdownTitle(document, source)
+	if title == "" {
+		title = filepath.Base(fPath)
+	}
+
+	var output bytes.Buffer
+	err = markdownTemplate.Execute(&output, map[string]interface{}{
+		"Title":   title,
+		"Content": template.HTML(body.String()),
+	})
+	return output.Bytes(), err
+}
+
+// markdownTitle provides the text of the first level one heading of the given
+// markdown document, to use as the page title, or an empty string if there is none.
+func markdownTitle(document ast.Node, source []byte) string {
+	var heading *ast.Heading
+	ast.Walk(document, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if found, ok := node.(*ast.Heading); ok && entering && found.Level == 1 {
+			heading = found
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	if heading == nil {
+		return ""
+	}
+
+	var title strings.Builder
+	ast.Walk(heading, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch inline := node.(type) {
+		case *ast.Text:
+			title.Write(inline.Segment.Value(source))
+			if inline.SoftLineBreak() {
+				title.WriteString(" ")
+			}
+		case *ast.String:
+			title.Write(inline.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(title.String())
+}
@@ -0,0 +1,524 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MockAPIConfig exposes a JSON file within the served folder as a REST API.
+type MockAPIConfig struct {
+	File   string `json:"file"`
+	Prefix string `json:"prefix"`
+}
+
+// mockRecord is a single item within a mock API resource collection.
+type mockRecord = map[string]interface{}
+
+// mockFilterSuffixes are the query parameter suffixes used for non-equality filters.
+var mockFilterSuffixes = []string{"_ne", "_like", "_gte", "_lte"}
+
+// SetMockAPI enables the mock API using the given config, or disables it if nil.
+func (fs *FileServer) SetMockAPI(config *MockAPIConfig) error {
+	if config != nil {
+		if config.File == "" {
+			config.File = "db.json"
+		}
+		if !strings.HasPrefix(config.Prefix, "/") {
+			return fmt.Errorf("mock API prefix %q must start with a /", config.Prefix)
+		}
+		if _, err := fs.mockAPIFilePath(config); err != nil {
+			return err
+		}
+	}
+
+	fs.mutex.Lock()
+	fs.MockAPI = config
+	fs.mutex.Unlock()
+	return nil
+}
+
+// matchMockAPI provides the mock API config if the given path falls under its prefix.
+func (fs *FileServer) matchMockAPI(path string) *MockAPIConfig {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	config := fs.MockAPI
+	if config == nil {
+		return nil
+	}
+	prefix := strings.TrimSuffix(config.Prefix, "/")
+	if path == prefix || strings.HasPrefix(path, prefix+"/") {
+		return config
+	}
+	return nil
+}
+
+func (fs *FileServer) mockAPIFilePath(config *MockAPIConfig) (string, error) {
+	fPath := filepath.Join(fs.RootPath, config.File)
+	if !strings.HasPrefix(fPath, fs.RootPath+string(filepath.Separator)) {
+		return "", fmt.Errorf("mock API file %q must be within the served folder", config.File)
+	}
+	// Keep the project config, which can set programs to run, from being written by API requests
+	if strings.EqualFold(fPath, filepath.Join(fs.RootPath, ConfigFileName)) {
+		return "", fmt.Errorf("mock API file cannot be the %s config file", ConfigFileName)
+	}
+	return fPath, nil
+}
+
+// serveMockAPI handles a REST request against the resources in the mock API JSON file.
+func (fs *FileServer) serveMockAPI(w http.ResponseWriter, r *http.Request, config *MockAPIConfig) {
+	w.Header().Set("Cache-Control", "no-cache")
+
+	fPath, err := fs.mockAPIFilePath(config)
+	if err != nil {
+		writeMockError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	fs.mockMutex.Lock()
+	defer fs.mockMutex.Unlock()
+
+	db, err := readMockDB(fPath)
+	if err != nil {
+		writeMockError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var segments []string
+	rest := strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(config.Prefix, "/"))
+	for _, segment := range strings.Split(rest, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	if len(segments) == 0 {
+		if r.Method != http.MethodGet {
+			writeMockError(w, http.StatusMethodNotAllowed, "the database root is read only")
+			return
+		}
+		writeMockJSON(w, http.StatusOK, db)
+		return
+	}
+
+	resource := segments[0]
+	if _, exists := db[resource]; !exists && r.Method != http.MethodPost {
+		writeMockError(w, http.StatusNotFound, fmt.Sprintf("resource %q not found", resource))
+		return
+	}
+
+	api := &mockAPIRequest{fs: fs, db: db, fPath: fPath, w: w, r: r, resource: resource}
+	switch len(segments) {
+	case 1:
+		api.serveResource()
+	case 2:
+		api.serveItem(segments[1])
+	case 3:
+		api.serveNested(segments[1], segments[2])
+	default:
+		writeMockError(w, http.StatusNotFound, "unsupported resource path")
+	}
+}
+
+// mockAPIRequest holds the state of a single request to the mock API.
+type mockAPIRequest struct {
+	fs       *FileServer
+	db       map[string]interface{}
+	fPath    string
+	w        http.ResponseWriter
+	r        *http.Request
+	resource string
+}
+
+func (api *mockAPIRequest) serveResource() {
+	items, isCollection := api.db[api.resource].([]interface{})
+
+	// Singular resources are stored as a single object
+	if object, isObject := api.db[api.resource].(mockRecord); isObject && !isCollection {
+		switch api.r.Method {
+		case http.MethodGet:
+			writeMockJSON(api.w, http.StatusOK, object)
+		case http.MethodPut, http.MethodPatch:
+			body, ok := api.readBody()
+			if !ok {
+				return
+			}
+			if api.r.Method == http.MethodPatch {
+				body = mergeRecords(object, body)
+			}
+			api.db[api.resource] = body
+			api.save("update", nil, http.StatusOK, body)
+		default:
+			writeMockError(api.w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return
+	}
+
+	// Other values can be read but not added to as a collection
+	if value, exists := api.db[api.resource]; exists && !isCollection {
+		if api.r.Method == http.MethodGet {
+			writeMockJSON(api.w, http.StatusOK, value)
+			return
+		}
+		writeMockError(api.w, http.StatusBadRequest, fmt.Sprintf("resource %q is not a collection", api.resource))
+		return
+	}
+
+	switch api.r.Method {
+	case http.MethodGet:
+		api.writeList(items, nil)
+	case http.MethodPost:
+		api.create(items, nil)
+	default:
+		writeMockError(api.w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (api *mockAPIRequest) serveItem(id string) {
+	items, _ := api.db[api.resource].([]interface{})
+	index := findMockRecord(items, id)
+	if index < 0 {
+		writeMockError(api.w, http.StatusNotFound, fmt.Sprintf("%s %q not found", api.resource, id))
+		return
+	}
+	record := items[index].(mockRecord)
+
+	switch api.r.Method {
+	case http.MethodGet:
+		writeMockJSON(api.w, http.StatusOK, record)
+	case http.MethodPut, http.MethodPatch:
+		body, ok := api.readBody()
+		if !ok {
+			return
+		}
+		if api.r.Method == http.MethodPatch {
+			body = mergeRecords(record, body)
+		}
+		body["id"] = record["id"]
+		items[index] = body
+		api.save("update", record["id"], http.StatusOK, body)
+	case http.MethodDelete:
+		api.db[api.resource] = append(items[:index], items[index+1:]...)
+		api.save("delete", record["id"], http.StatusOK, mockRecord{})
+	default:
+		writeMockError(api.w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// serveNested handles requests for child resources of a parent item,
+// such as /posts/1/comments, matched using a postId field.
+func (api *mockAPIRequest) serveNested(parentID string, child string) {
+	parents, _ := api.db[api.resource].([]interface{})
+	parentIndex := findMockRecord(parents, parentID)
+	if parentIndex < 0 {
+		writeMockError(api.w, http.StatusNotFound, fmt.Sprintf("%s %q not found", api.resource, parentID))
+		return
+	}
+
+	foreignKey := singularise(api.resource) + "Id"
+	parentRecord := parents[parentIndex].(mockRecord)
+	children, _ := api.db[child].([]interface{})
+	api.resource = child
+
+	switch api.r.Method {
+	case http.MethodGet:
+		api.writeList(children, url.Values{foreignKey: {parentID}})
+	case http.MethodPost:
+		api.create(children, mockRecord{foreignKey: parentRecord["id"]})
+	default:
+		writeMockError(api.w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (api *mockAPIRequest) create(items []interface{}, extra mockRecord) {
+	body, ok := api.readBody()
+	if !ok {
+		return
+	}
+
+	for key, value := range extra {
+		body[key] = value
+	}
+	if _, hasID := body["id"]; !hasID {
+		body["id"] = nextMockID(items)
+	}
+	if findMockRecord(items, fmt.Sprint(body["id"])) >= 0 {
+		writeMockError(api.w, http.StatusConflict, fmt.Sprintf("%s %v already exists", api.resource, body["id"]))
+		return
+	}
+
+	api.db[api.resource] = append(items, body)
+	api.save("create", body["id"], http.StatusCreated, body)
+}
+
+// writeList filters, sorts and paginates the given items using the request query.
+func (api *mockAPIRequest) writeList(items []interface{}, extraFilters url.Values) {
+	query := api.r.URL.Query()
+	for key, values := range extraFilters {
+		query[key] = values
+	}
+
+	var records []mockRecord
+	for _, item := range items {
+		if record, ok := item.(mockRecord); ok && matchesMockFilters(record, query) {
+			records = append(records, record)
+		}
+	}
+
+	if sortFields := query.Get("_sort"); sortFields != "" {
+		sortMockRecords(records, strings.Split(sortFields, ","), strings.Split(query.Get("_order"), ","))
+	}
+
+	total := len(records)
+	api.w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	api.w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count")
+
+	if page, err := strconv.Atoi(query.Get("_page")); err == nil && page > 0 {
+		limit, err := strconv.Atoi(query.Get("_limit"))
+		if err != nil || limit < 1 {
+			limit = 10
+		}
+		start := (page - 1) * limit
+		if start > total {
+			start = total
+		}
+		end := start + limit
+		if end > total {
+			end = total
+		}
+		records = records[start:end]
+	} else if limit, err := strconv.Atoi(query.Get("_limit")); err == nil && limit >= 0 && limit < total {
+		records = records[:limit]
+	}
+
+	if records == nil {
+		records = []mockRecord{}
+	}
+	writeMockJSON(api.w, http.StatusOK, records)
+}
+
+func (api *mockAPIRequest) readBody() (mockRecord, bool) {
+	data, err := ioutil.ReadAll(api.r.Body)
+	if err == nil && len(bytes.TrimSpace(data)) == 0 {
+		data = []byte("{}")
+	}
+
+	// Decode any JSON value so null, arrays and scalars can be told apart from objects
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err == nil {
+		err = decoder.Decode(&value)
+	}
+	body, isObject := value.(mockRecord)
+	if err != nil || !isObject {
+		writeMockError(api.w, http.StatusBadRequest, "request body must be a JSON object")
+		return nil, false
+	}
+	return body, true
+}
+
+// save writes the database back to its file, notifies any listening pages
+// of the change and responds with the given data.
+func (api *mockAPIRequest) save(action string, id interface{}, status int, response interface{}) {
+	data, err := json.MarshalIndent(api.db, "", "  ")
+	if err == nil {
+		api.fs.markInternalWrite(api.fPath)
+		err = ioutil.WriteFile(api.fPath, data, 0644)
+	}
+	if err != nil {
+		writeMockError(api.w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	api.fs.sendEvent("mock-api", map[string]interface{}{
+		"resource": api.resource,
+		"action":   action,
+		"id":       id,
+	})
+	writeMockJSON(api.w, status, response)
+}
+
+func readMockDB(fPath string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(fPath)
+	if err != nil {
+		return nil, err
+	}
+
+	db := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&db)
+	return db, err
+}
+
+func findMockRecord(items []interface{}, id string) int {
+	for i, item := range items {
+		if record, ok := item.(mockRecord); ok && fmt.Sprint(record["id"]) == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// nextMockID provides the next numeric id for a collection, or a random
+// string id if the collection already uses non-numeric ids.
+func nextMockID(items []interface{}) interface{} {
+	max := int64(0)
+	for _, item := range items {
+		record, _ := item.(mockRecord)
+		id, err := strconv.ParseInt(fmt.Sprint(record["id"]), 10, 64)
+		if err != nil {
+			random := make([]byte, 6)
+			rand.Read(random)
+			return hex.EncodeToString(random)
+		}
+		if id > max {
+			max = id
+		}
+	}
+	return json.Number(strconv.FormatInt(max+1, 10))
+}
+
+func mergeRecords(base mockRecord, changes mockRecord) mockRecord {
+	merged := make(mockRecord)
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range changes {
+		merged[key] = value
+	}
+	return merged
+}
+
+// mockField finds a value within a record using a dot separated field path.
+func mockField(record mockRecord, field string) (interface{}, bool) {
+	var current interface{} = record
+	for _, part := range strings.Split(field, ".") {
+		object, ok := current.(mockRecord)
+		if !ok {
+			return nil, false
+		}
+		current, ok = object[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func matchesMockFilters(record mockRecord, query url.Values) bool {
+	for key, values := range query {
+		if strings.HasPrefix(key, "_") {
+			continue
+		}
+
+		if key == "q" {
+			data, _ := json.Marshal(record)
+			if !strings.Contains(strings.ToLower(string(data)), strings.ToLower(values[0])) {
+				return false
+			}
+			continue
+		}
+
+		field, operator := key, ""
+		for _, suffix := range mockFilterSuffixes {
+			if strings.HasSuffix(key, suffix) {
+				field, operator = strings.TrimSuffix(key, suffix), suffix
+				break
+			}
+		}
+
+		value, exists := mockField(record, field)
+		actual := fmt.Sprint(value)
+		matched := false
+		for _, expected := range values {
+			switch operator {
+			case "_ne":
+				matched = !exists || actual != expected
+			case "_like":
+				matched = exists && strings.Contains(strings.ToLower(actual), strings.ToLower(expected))
+			case "_gte":
+				matched = exists && compareMockValues(actual, expected) >= 0
+			case "_lte":
+				matched = exists && compareMockValues(actual, expected) <= 0
+			default:
+				matched = exists && actual == expected
+			}
+			if matched {
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func sortMockRecords(records []mockRecord, fields []string, orders []string) {
+	sort.SliceStable(records, func(i, j int) bool {
+		for index, field := range fields {
+			a, _ := mockField(records[i], field)
+			b, _ := mockField(records[j], field)
+			comparison := compareMockValues(fmt.Sprint(a), fmt.Sprint(b))
+			if comparison == 0 {
+				continue
+			}
+			if index < len(orders) && strings.ToLower(orders[index]) == "desc" {
+				return comparison > 0
+			}
+			return comparison < 0
+		}
+		return false
+	})
+}
+
+// compareMockValues compares two values numerically if possible, otherwise as strings.
+func compareMockValues(a string, b string) int {
+	aNum, aErr := strconv.ParseFloat(a, 64)
+	bNum, bErr := strconv.ParseFloat(b, 64)
+	if aErr == nil && bErr == nil {
+		switch {
+		case aNum < bNum:
+			return -1
+		case aNum > bNum:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+// singularise provides a simple singular form of a plural resource name.
+func singularise(name string) string {
+	switch {
+	case strings.HasSuffix(name, "ies"):
+		return strings.TrimSuffix(name, "ies") + "y"
+	case strings.HasSuffix(name, "s"):
+		return strings.TrimSuffix(name, "s")
+	}
+	return name
+}
+
+func writeMockJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(data)
+}
+
+func writeMockError(w http.ResponseWriter, status int, message string) {
+	writeMockJSON(w, status, map[string]string{"error": message})
+}
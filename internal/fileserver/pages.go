@@ -0,0 +1,301 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dataDir is the folder, relative to the server root, holding JSON data files for page templates.
+const dataDir = "_data"
+
+// maxIncludeDepth limits how deeply includes and layouts can be nested.
+const maxIncludeDepth = 10
+
+// includeRegex matches server-side include directives such as <!--#include virtual="/header.html" -->
+var includeRegex = regexp.MustCompile(`<!--#include\s+(virtual|file)="([^"]+)"\s*-->`)
+
+var pageErrorTemplate = template.Must(template.New("PageError").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<title>Page Error</title>
+	<style>body { font-family: sans-serif; max-width: 800px; margin: 40px auto; padding: 0 16px; color: #333; } pre { background: #FDECEC; color: #A22; padding: 16px; overflow: auto; }</style>
+</head>
+<body>
+	<h1>Could not render {{.File}}</h1>
+	<pre>{{.Error}}</pre>
+</body>
+</html>
+`))
+
+// pageData is provided to page templates and layouts.
+type pageData struct {
+	Page    map[string]string
+	Data    map[string]interface{}
+	Path    string
+	Content template.HTML
+}
+
+// pageRender tracks the files used while rendering a single page.
+type pageRender struct {
+	fs           *FileServer
+	dependencies map[string]bool
+	usesData     bool
+}
+
+// renderPage renders the HTML page at the given path, processing server-side includes and,
+// for pages starting with front-matter, html/template variables, data files and layouts.
+// The latest modification time of the page and the files it depends on is also provided.
+func (fs *FileServer) renderPage(fPath string, requestPath string) ([]byte, time.Time, error) {
+	render := &pageRender{fs: fs, dependencies: make(map[string]bool)}
+	defer fs.recordDependencies(fPath, render.dependencies)
+
+	content, err := render.render(fPath, requestPath)
+	return content, render.modTime(fPath), err
+}
+
+func (render *pageRender) render(fPath string, requestPath string) ([]byte, error) {
+	content, err := render.readWithIncludes(fPath, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	vars, body, hasFrontMatter := parseFrontMatter(content)
+	if !hasFrontMatter {
+		return content, nil
+	}
+
+	data := &pageData{Page: vars, Path: requestPath}
+	for depth := 0; ; depth++ {
+		if depth > maxIncludeDepth {
+			return nil, fmt.Errorf("layouts nested more than %d levels deep", maxIncludeDepth)
+		}
+
+		if data.Data == nil {
+			data.Data, err = render.loadData()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		rendered, err := executePageTemplate(fPath, body, data)
+		if err != nil {
+			return nil, err
+		}
+
+		layout := vars["layout"]
+		if layout == "" {
+			return rendered, nil
+		}
+
+		layoutPath, err := render.fs.resolvePagePath(layout, render.fs.RootPath)
+		if err != nil {
+			return nil, err
+		}
+		render.dependencies[layoutPath] = true
+		layoutContent, err := render.readWithIncludes(layoutPath, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		// Layouts can set their own variables, and parent layout, via front-matter
+		layoutVars, layoutBody, _ := parseFrontMatter(layoutContent)
+		for name, value := range vars {
+			if _, exists := layoutVars[name]; !exists && name != "layout" {
+				layoutVars[name] = value
+			}
+		}
+
+		fPath, vars, body = layoutPath, layoutVars, layoutBody
+		data.Page = vars
+		data.Content = template.HTML(rendered)
+	}
+}
+
+// modTime finds the latest modification time of the given page and the files used to render it.
+// The data folder is included so data files added since are noticed.
+func (render *pageRender) modTime(fPath string) time.Time {
+	paths := []string{fPath}
+	for dependency := range render.dependencies {
+		paths = append(paths, dependency)
+	}
+	if render.usesData {
+		paths = append(paths, filepath.Join(render.fs.RootPath, dataDir))
+	}
+
+	var latest time.Time
+	for _, dependency := range paths {
+		if stat, err := os.Stat(dependency); err == nil && stat.ModTime().After(latest) {
+			latest = stat.ModTime()
+		}
+	}
+	return latest
+}
+
+// readWithIncludes reads the given file, replacing any include directives with the included file contents.
+func (render *pageRender) readWithIncludes(fPath string, depth int) ([]byte, error) {
+	if depth > maxIncludeDepth {
+		return nil, fmt.Errorf("includes nested more than %d levels deep", maxIncludeDepth)
+	}
+
+	content, err := os.ReadFile(fPath)
+	if err != nil {
+		return nil, err
+	}
+	if depth > 0 {
+		render.dependencies[fPath] = true
+	}
+
+	var includeErr error
+	content = includeRegex.ReplaceAllFunc(content, func(directive []byte) []byte {
+		if includeErr != nil {
+			return nil
+		}
+
+		parts := includeRegex.FindSubmatch(directive)
+		baseDir := filepath.Dir(fPath)
+		if string(parts[1]) == "virtual" && strings.HasPrefix(string(parts[2]), "/") {
+			baseDir = render.fs.RootPath
+		}
+
+		includePath, err := render.fs.resolvePagePath(string(parts[2]), baseDir)
+		if err == nil {
+			var included []byte
+			included, err = render.readWithIncludes(includePath, depth+1)
+			if err == nil {
+				return included
+			}
+		}
+
+		includeErr = fmt.Errorf("including %q in %s: %s", parts[2], filepath.Base(fPath), err.Error())
+		return nil
+	})
+
+	return content, includeErr
+}
+
+// loadData loads each JSON file in the project data folder, keyed by file name.
+func (render *pageRender) loadData() (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	dir := filepath.Join(render.fs.RootPath, dataDir)
+	render.usesData = true
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var value interface{}
+		if err := json.Unmarshal(content, &value); err != nil {
+			return nil, fmt.Errorf("data file %s: %s", filepath.Base(file), err.Error())
+		}
+		data[strings.TrimSuffix(filepath.Base(file), ".json")] = value
+		render.dependencies[file] = true
+	}
+
+	return data, nil
+}
+
+// resolvePagePath resolves an include or layout path, relative to the given
+// folder, ensuring it stays within the served folder.
+func (fs *FileServer) resolvePagePath(name string, baseDir string) (string, error) {
+	fPath := filepath.Join(baseDir, filepath.FromSlash(name))
+	if !strings.HasPrefix(fPath, fs.RootPath+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q must be within the served folder", name)
+	}
+	return fPath, nil
+}
+
+func executePageTemplate(fPath string, content []byte, data *pageData) ([]byte, error) {
+	tmpl, err := template.New(filepath.Base(fPath)).Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+
+	var output bytes.Buffer
+	err = tmpl.Execute(&output, data)
+	return output.Bytes(), err
+}
+
+// parseFrontMatter splits "key: value" front-matter, between --- lines
+// at the start of the given content, from the rest of the content.
+func parseFrontMatter(content []byte) (map[string]string, []byte, bool) {
+	vars := make(map[string]string)
+	normalised := bytes.Replace(content, []byte("\r\n"), []byte("\n"), -1)
+	if !bytes.HasPrefix(normalised, []byte("---\n")) {
+		return vars, content, false
+	}
+
+	end := bytes.Index(normalised[4:], []byte("\n---"))
+	if end == -1 {
+		return vars, content, false
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(normalised[4 : 4+end]))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 || strings.HasPrefix(strings.TrimSpace(parts[0]), "#") {
+			continue
+		}
+		vars[strings.TrimSpace(parts[0])] = strings.Trim(strings.TrimSpace(parts[1]), `"'`)
+	}
+
+	body := normalised[4+end+4:]
+	if index := bytes.IndexByte(body, '\n'); index != -1 && len(bytes.TrimSpace(body[:index])) == 0 {
+		body = body[index+1:]
+	}
+	return vars, body, true
+}
+
+// servePage renders the given HTML page, with an import map for installed packages and
+// the live reload script if enabled, showing an error page if rendering fails.
+// Rendered pages can be revalidated against the latest change to the page or its dependencies.
+func (fs *FileServer) servePage(w http.ResponseWriter, r *http.Request, fPath string) {
+	content, modTime, err := fs.renderPage(fPath, r.URL.Path)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err != nil {
+		relPath, _ := filepath.Rel(fs.RootPath, fPath)
+		fs.logFor(r).Error("Rendering "+relPath, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(fs.injectLiveReload(renderPageError(relPath, err), r))
+		return
+	}
+
+	// Generated import maps change along with the installed packages
+	if packagesTime := packagesModTime(fs.RootPath, filepath.Join(fs.RootPath, "node_modules")); packagesTime.After(modTime) {
+		modTime = packagesTime
+	}
+
+	content = fs.injectLiveReload(fs.injectImportMap(content), r)
+	http.ServeContent(w, r, filepath.Base(fPath), modTime, bytes.NewReader(content))
+}
+
+// renderPageError renders the page shown in place of a page that could not be rendered.
+func renderPageError(relPath string, err error) []byte {
+	var page bytes.Buffer
+	pageErrorTemplate.Execute(&page, map[string]string{"File": relPath, "Error": err.Error()})
+	return page.Bytes()
+}
+
+// injectLiveReload adds the live reload script, if enabled, to the given page before its closing body tag.
+func (fs *FileServer) injectLiveReload(page []byte, r *http.Request) []byte {
+	if !fs.liveReloadEnabled() {
+		return page
+	}
+	return injectScript(page, fs.liveReloadScriptTag(r.Host))
+}
@@ -0,0 +1,270 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/ssddanbrown/webby/internal/logger"
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/html"
+	"github.com/tdewolff/minify/v2/js"
+	"github.com/tdewolff/minify/v2/json"
+	"github.com/tdewolff/minify/v2/svg"
+)
+
+// previewCacheControl is the caching header sent for responses in production preview mode.
+const previewCacheControl = "public, max-age=3600"
+
+// maxMinifyCacheEntries is how many paths have their minified output kept for reuse.
+const maxMinifyCacheEntries = 500
+
+// minifiableTypeRegex matches the media types that have a minifier.
+var minifiableTypeRegex = regexp.MustCompile(`^(text/html|text/css|image/svg\+xml|(application|text)/(x-)?(java|ecma)script|.+[/+]json)$`)
+
+var minifier = newMinifier()
+
+func newMinifier() *minify.M {
+	m := minify.New()
+	m.Add("text/html", &html.Minifier{KeepDocumentTags: true, KeepEndTags: true})
+	m.AddFunc("text/css", css.Minify)
+	m.AddFunc("image/svg+xml", svg.Minify)
+	m.AddFuncRegexp(regexp.MustCompile(`^(application|text)/(x-)?(java|ecma)script$`), js.Minify)
+	m.AddFuncRegexp(regexp.MustCompile(`[/+]json$`), json.Minify)
+	return m
+}
+
+// MinifyStat records the size of a response before and after minification.
+type MinifyStat struct {
+	Path     string `json:"path"`
+	Original int    `json:"original"`
+	Minified int    `json:"minified"`
+}
+
+// Saving provides the percentage of bytes saved by minification.
+func (stat *MinifyStat) Saving() int {
+	if stat.Original == 0 {
+		return 0
+	}
+	return (stat.Original - stat.Minified) * 100 / stat.Original
+}
+
+// minifiedOutput is a minified response body along with the modification time of the
+// file it was minified from, or a hash of the source for responses not served from a file.
+type minifiedOutput struct {
+	modTime    time.Time
+	sourceHash [sha256.Size]byte
+	sourceSize int
+	output     []byte
+}
+
+// SetProductionPreview switches production preview mode, which minifies responses,
+// sends realistic caching headers and stops injecting the live reload script.
+func (fs *FileServer) SetProductionPreview(enabled bool) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.setProductionPreview(enabled)
+}
+
+// ToggleProductionPreview switches production preview mode, returning if it is now enabled.
+func (fs *FileServer) ToggleProductionPreview() bool {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.setProductionPreview(!fs.ProductionPreview)
+	return fs.ProductionPreview
+}
+
+// setProductionPreview switches production preview mode, starting with no minified output.
+// The server mutex must be held.
+func (fs *FileServer) setProductionPreview(enabled bool) {
+	fs.ProductionPreview = enabled
+	fs.minifyStats = make(map[string]*MinifyStat)
+	fs.minifyCache = make(map[string]*minifiedOutput)
+}
+
+// MinifyStats provides the minification savings for each path served in production preview mode.
+func (fs *FileServer) MinifyStats() []*MinifyStat {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	var stats []*MinifyStat
+	for _, stat := range fs.minifyStats {
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Path < stats[j].Path
+	})
+	return stats
+}
+
+func (fs *FileServer) productionPreview() bool {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+	return fs.ProductionPreview
+}
+
+// liveReloadEnabled checks if the live reload script should be injected into pages.
+func (fs *FileServer) liveReloadEnabled() bool {
+	return fs.options.LiveReloadEnabled && !fs.productionPreview()
+}
+
+// cacheControl provides the caching header to send for files served from disk.
+func (fs *FileServer) cacheControl() string {
+	if fs.productionPreview() {
+		return previewCacheControl
+	}
+	return "no-cache"
+}
+
+// minify minifies the given response body, reusing the previous output for the path
+// while its source is unchanged, and records the bytes saved. Responses served from a file
+// are checked against the given modification time, rather than hashing each response.
+func (fs *FileServer) minify(path string, mediaType string, source []byte, modTime time.Time) []byte {
+	fs.mutex.RLock()
+	cached := fs.minifyCache[path]
+	fs.mutex.RUnlock()
+
+	var hash [sha256.Size]byte
+	unchanged := false
+	if !modTime.IsZero() {
+		unchanged = cached != nil && cached.modTime.Equal(modTime) && cached.sourceSize == len(source)
+	} else {
+		hash = sha256.Sum256(source)
+		unchanged = cached != nil && cached.modTime.IsZero() && cached.sourceHash == hash
+	}
+
+	output := source
+	if unchanged {
+		output = cached.output
+	} else {
+		minified, err := minifier.Bytes(mediaType, source)
+		if err != nil {
+			logger.Error("Minifying "+path, err)
+		} else {
+			output = minified
+		}
+	}
+
+	fs.mutex.Lock()
+	if fs.minifyCache != nil {
+		// Make room by dropping any other path once the cache is full
+		if _, ok := fs.minifyCache[path]; !ok && len(fs.minifyCache) >= maxMinifyCacheEntries {
+			for oldPath := range fs.minifyCache {
+				delete(fs.minifyCache, oldPath)
+				delete(fs.minifyStats, oldPath)
+				break
+			}
+		}
+		fs.minifyCache[path] = &minifiedOutput{modTime: modTime, sourceHash: hash, sourceSize: len(source), output: output}
+		fs.minifyStats[path] = &MinifyStat{Path: path, Original: len(source), Minified: len(output)}
+	}
+	fs.mutex.Unlock()
+
+	return output
+}
+
+// setMinifySource notes the file a response is served from, if it is being minified,
+// so its minified output can be reused until the file changes.
+func setMinifySource(w http.ResponseWriter, fPath string) {
+	for {
+		switch writer := w.(type) {
+		case *minifyResponseWriter:
+			writer.source = fPath
+			return
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = writer.Unwrap()
+		default:
+			return
+		}
+	}
+}
+
+// sourceModTime provides the modification time of the file, or folder index, a response
+// is served from, or a zero time if unknown.
+func sourceModTime(fPath string) time.Time {
+	stat, err := os.Stat(fPath)
+	if err == nil && stat.IsDir() {
+		stat, err = os.Stat(filepath.Join(fPath, "index.html"))
+	}
+	if err != nil {
+		return time.Time{}
+	}
+	return stat.ModTime()
+}
+
+// isMinifiableType checks if there is a minifier for the given media type.
+func isMinifiableType(mediaType string) bool {
+	return minifiableTypeRegex.MatchString(mediaType)
+}
+
+// minifyResponseWriter buffers successful responses of minifiable
+// types so they can be minified once complete.
+type minifyResponseWriter struct {
+	http.ResponseWriter
+	fs          *FileServer
+	path        string
+	source      string
+	mediaType   string
+	wroteHeader bool
+	buffer      *bytes.Buffer
+}
+
+func (fs *FileServer) newMinifyResponseWriter(w http.ResponseWriter, path string) *minifyResponseWriter {
+	return &minifyResponseWriter{ResponseWriter: w, fs: fs, path: path}
+}
+
+func (mw *minifyResponseWriter) WriteHeader(status int) {
+	if mw.wroteHeader {
+		return
+	}
+	mw.wroteHeader = true
+
+	mediaType, _, _ := mime.ParseMediaType(mw.Header().Get("Content-Type"))
+	if status == http.StatusOK && mw.Header().Get("Content-Encoding") == "" && isMinifiableType(mediaType) {
+		mw.mediaType = mediaType
+		mw.buffer = new(bytes.Buffer)
+		mw.Header().Del("Content-Length")
+		return
+	}
+	mw.ResponseWriter.WriteHeader(status)
+}
+
+func (mw *minifyResponseWriter) Write(data []byte) (int, error) {
+	if !mw.wroteHeader {
+		if mw.Header().Get("Content-Type") == "" {
+			mw.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		mw.WriteHeader(http.StatusOK)
+	}
+	if mw.buffer != nil {
+		return mw.buffer.Write(data)
+	}
+	return mw.ResponseWriter.Write(data)
+}
+
+// Close minifies and writes any buffered response.
+func (mw *minifyResponseWriter) Close() {
+	if mw.buffer == nil {
+		return
+	}
+	var modTime time.Time
+	if mw.source != "" {
+		modTime = sourceModTime(mw.source)
+	}
+	output := mw.fs.minify(mw.path, mw.mediaType, mw.buffer.Bytes(), modTime)
+	mw.ResponseWriter.WriteHeader(http.StatusOK)
+	mw.ResponseWriter.Write(output)
+	mw.buffer = nil
+}
+
+// Unwrap provides the underlying response writer for use by http.ResponseController.
+func (mw *minifyResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
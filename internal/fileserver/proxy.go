@@ -0,0 +1,143 @@
+package fileserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// cookieDomainRegex matches the domain attribute of a Set-Cookie header.
+var cookieDomainRegex = regexp.MustCompile(`(?i);\s*domain=[^;]*`)
+
+// bodyCloseRegex matches the closing body tag of a HTML document.
+var bodyCloseRegex = regexp.MustCompile(`(?i)</body>`)
+
+// newReverseProxy creates a reverse proxy to the given upstream that rewrites
+// responses so they work, with livereload, when served by this server.
+func (fs *FileServer) newReverseProxy(upstream *url.URL) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	director := proxy.Director
+
+	proxy.Director = func(r *http.Request) {
+		r.Header.Set("X-Forwarded-Host", r.Host)
+		r.Header.Set("X-Forwarded-Proto", fs.Scheme())
+		director(r)
+		r.Host = upstream.Host
+
+		// Only ask for encodings we are able to decode when injecting livereload
+		var encodings []string
+		accepted := acceptedEncodings(r.Header.Get("Accept-Encoding"))
+		for _, encoding := range []string{"br", "gzip"} {
+			if accepted[encoding] {
+				encodings = append(encodings, encoding)
+			}
+		}
+		r.Header.Set("Accept-Encoding", strings.Join(encodings, ", "))
+	}
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		requestHost := resp.Request.Header.Get("X-Forwarded-Host")
+		fs.rewriteProxyLocation(resp, upstream, requestHost)
+		rewriteProxyCookies(resp)
+
+		// Responses recorded as fixtures have live reload injected once recorded
+		recording := resp.Request.Context().Value(fixtureRequestKey{}) != nil
+		if fs.liveReloadEnabled() && isHTMLResponse(resp) && !recording {
+			return injectIntoProxyResponse(resp, fs.liveReloadScriptTag(requestHost))
+		}
+		return nil
+	}
+
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		fs.logFor(r).Error("Proxy request to "+upstream.String(), err)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("Webby could not reach the proxied server at " + upstream.String()))
+	}
+
+	return proxy
+}
+
+// rewriteProxyLocation changes redirects that point to the upstream server
+// so they point back at this server instead.
+func (fs *FileServer) rewriteProxyLocation(resp *http.Response, upstream *url.URL, requestHost string) {
+	location, err := resp.Location()
+	if err != nil || location.Host != upstream.Host {
+		return
+	}
+
+	location.Scheme = fs.Scheme()
+	location.Host = requestHost
+	resp.Header.Set("Location", location.String())
+}
+
+// rewriteProxyCookies removes domain attributes from upstream cookies
+// so browsers store them against this server's host.
+func rewriteProxyCookies(resp *http.Response) {
+	cookies := resp.Header.Values("Set-Cookie")
+	resp.Header.Del("Set-Cookie")
+	for _, cookie := range cookies {
+		resp.Header.Add("Set-Cookie", cookieDomainRegex.ReplaceAllString(cookie, ""))
+	}
+}
+
+func isHTMLResponse(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return resp.StatusCode == http.StatusOK && mediaType == "text/html"
+}
+
+// injectIntoProxyResponse decodes the given response body and inserts the given
+// script tag before the closing body tag, or at the end of the document.
+func injectIntoProxyResponse(resp *http.Response, scriptTag string) error {
+	var reader io.Reader = resp.Body
+	switch resp.Header.Get("Content-Encoding") {
+	case "gzip":
+		gzipReader, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		reader = gzipReader
+	case "br":
+		reader = brotli.NewReader(resp.Body)
+	case "", "identity":
+	default:
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(reader)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	body = injectScript(body, scriptTag)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// injectScript inserts the given script tag before the last closing body tag of
+// the given HTML, or appends it to the end if no closing tag exists.
+func injectScript(html []byte, scriptTag string) []byte {
+	matches := bodyCloseRegex.FindAllIndex(html, -1)
+	if len(matches) == 0 {
+		return append(html, scriptTag...)
+	}
+
+	index := matches[len(matches)-1][0]
+	result := make([]byte, 0, len(html)+len(scriptTag))
+	result = append(result, html[:index]...)
+	result = append(result, scriptTag...)
+	return append(result, html[index:]...)
+}
@@ -0,0 +1,104 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// ProxyRule forwards requests under a path prefix on to an upstream server
+// before any static file lookup takes place.
+type ProxyRule struct {
+	Prefix   string            `json:"prefix"`
+	Upstream string            `json:"upstream"`
+	Rewrite  string            `json:"rewrite,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	proxy    *httputil.ReverseProxy
+}
+
+// AddProxyRule validates and adds the given proxy rule to the server.
+func (fs *FileServer) AddProxyRule(rule *ProxyRule) error {
+	upstream, err := url.Parse(rule.Upstream)
+	if err != nil {
+		return err
+	}
+	if upstream.Scheme == "" || upstream.Host == "" {
+		return fmt.Errorf("proxy rule upstream %q must be an absolute URL", rule.Upstream)
+	}
+	if !strings.HasPrefix(rule.Prefix, "/") {
+		return fmt.Errorf("proxy rule prefix %q must start with a /", rule.Prefix)
+	}
+
+	rule.proxy = fs.newRuleProxy(rule, upstream)
+
+	fs.mutex.Lock()
+	fs.ProxyRules = append(fs.ProxyRules, rule)
+	fs.mutex.Unlock()
+	return nil
+}
+
+// RemoveProxyRule removes the proxy rule at the given index from the server.
+func (fs *FileServer) RemoveProxyRule(index int) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if index < 0 || index >= len(fs.ProxyRules) {
+		return fmt.Errorf("proxy rule %d not found", index)
+	}
+	fs.ProxyRules = append(fs.ProxyRules[:index], fs.ProxyRules[index+1:]...)
+	return nil
+}
+
+// matchProxyRule finds the proxy rule with the longest prefix matching the given path.
+func (fs *FileServer) matchProxyRule(path string) *ProxyRule {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	var match *ProxyRule
+	for _, rule := range fs.ProxyRules {
+		if rule.matches(path) && (match == nil || len(rule.Prefix) > len(match.Prefix)) {
+			match = rule
+		}
+	}
+	return match
+}
+
+func (rule *ProxyRule) matches(path string) bool {
+	prefix := strings.TrimSuffix(rule.Prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/") || prefix == ""
+}
+
+// newRuleProxy creates a reverse proxy to the upstream of the given rule, logging failures against this server.
+func (fs *FileServer) newRuleProxy(rule *ProxyRule, upstream *url.URL) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	director := proxy.Director
+
+	proxy.Director = func(r *http.Request) {
+		if rule.Rewrite != "" {
+			prefix := strings.TrimSuffix(rule.Prefix, "/")
+			path := strings.TrimSuffix(rule.Rewrite, "/") + strings.TrimPrefix(r.URL.Path, prefix)
+			if !strings.HasPrefix(path, "/") {
+				path = "/" + path
+			}
+			r.URL.Path = path
+			r.URL.RawPath = ""
+		}
+
+		r.Header.Set("X-Forwarded-Host", r.Host)
+		director(r)
+		r.Host = upstream.Host
+
+		for name, value := range rule.Headers {
+			r.Header.Set(name, value)
+		}
+	}
+
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		fs.logFor(r).Error("Proxy rule request to "+upstream.String(), err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	return proxy
+}
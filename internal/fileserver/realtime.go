@@ -0,0 +1,362 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ssddanbrown/webby/internal/logger"
+	"golang.org/x/net/websocket"
+)
+
+// Realtime endpoint types that can be mocked by a file server.
+const (
+	RealtimeBroadcast = "broadcast"
+	RealtimeEcho      = "echo"
+	RealtimeSSE       = "sse"
+)
+
+// RealtimeEndpoint is a mock WebSocket or Server-Sent Events endpoint.
+// Broadcast sockets share messages between all connected pages, echo sockets
+// send messages back to the sender and SSE streams replay the events of a file.
+type RealtimeEndpoint struct {
+	Type      string `json:"type"`
+	Path      string `json:"path"`
+	File      string `json:"file,omitempty"`
+	Loop      bool   `json:"loop,omitempty"`
+	sockets   *socketGroup
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// StreamEvent is a single event replayed by a mock SSE stream.
+// Delay is the number of milliseconds to wait before sending the event.
+type StreamEvent struct {
+	Event string          `json:"event"`
+	ID    string          `json:"id"`
+	Data  json.RawMessage `json:"data"`
+	Delay int             `json:"delay"`
+}
+
+// minStreamLoopTime is the least time a looping event stream takes to replay its events,
+// so streams without delays do not flood the browser.
+const minStreamLoopTime = time.Second
+
+// socketQueueSize is how many messages can wait to be sent to a broadcast connection
+// before further messages are dropped for it.
+const socketQueueSize = 64
+
+// socketGroup tracks the connections to a broadcast socket, each with a queue of messages to send.
+type socketGroup struct {
+	sync.Mutex
+	conns map[*websocket.Conn]chan socketMessage
+}
+
+type socketMessage struct {
+	payloadType byte
+	data        []byte
+}
+
+// AddRealtimeEndpoint adds a mock realtime endpoint, replacing any existing endpoint on the same path.
+func (fs *FileServer) AddRealtimeEndpoint(endpoint *RealtimeEndpoint) error {
+	if !strings.HasPrefix(endpoint.Path, "/") {
+		return fmt.Errorf("realtime endpoint path %q must start with a /", endpoint.Path)
+	}
+
+	switch endpoint.Type {
+	case RealtimeBroadcast:
+		endpoint.sockets = &socketGroup{conns: make(map[*websocket.Conn]chan socketMessage)}
+	case RealtimeEcho:
+	case RealtimeSSE:
+		if _, err := fs.streamFilePath(endpoint); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown realtime endpoint type %q", endpoint.Type)
+	}
+	endpoint.closed = make(chan struct{})
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	for index, existing := range fs.Realtime {
+		if existing.Path == endpoint.Path {
+			existing.closeSockets()
+			fs.Realtime[index] = endpoint
+			return nil
+		}
+	}
+	fs.Realtime = append(fs.Realtime, endpoint)
+	return nil
+}
+
+// RemoveRealtimeEndpoint removes the realtime endpoint at the given index from the server.
+func (fs *FileServer) RemoveRealtimeEndpoint(index int) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if index < 0 || index >= len(fs.Realtime) {
+		return fmt.Errorf("realtime endpoint %d not found", index)
+	}
+	fs.Realtime[index].closeSockets()
+	fs.Realtime = append(fs.Realtime[:index], fs.Realtime[index+1:]...)
+	return nil
+}
+
+// matchRealtimeEndpoint finds the realtime endpoint for the given request path.
+func (fs *FileServer) matchRealtimeEndpoint(path string) *RealtimeEndpoint {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	for _, endpoint := range fs.Realtime {
+		if endpoint.Path == path {
+			return endpoint
+		}
+	}
+	return nil
+}
+
+func (fs *FileServer) streamFilePath(endpoint *RealtimeEndpoint) (string, error) {
+	fPath := filepath.Join(fs.RootPath, endpoint.File)
+	if !strings.HasPrefix(fPath, fs.RootPath+string(filepath.Separator)) {
+		return "", fmt.Errorf("event stream file %q must be within the served folder", endpoint.File)
+	}
+	return fPath, nil
+}
+
+// serveRealtime handles a request to a mock realtime endpoint.
+func (fs *FileServer) serveRealtime(w http.ResponseWriter, r *http.Request, endpoint *RealtimeEndpoint) {
+	switch endpoint.Type {
+	case RealtimeBroadcast:
+		websocket.Handler(endpoint.sockets.serve).ServeHTTP(w, r)
+	case RealtimeEcho:
+		websocket.Handler(serveEchoSocket).ServeHTTP(w, r)
+	case RealtimeSSE:
+		fs.serveEventStream(w, r, endpoint)
+	}
+}
+
+// closeSockets ends all connections to the endpoint, including event streams.
+func (endpoint *RealtimeEndpoint) closeSockets() {
+	endpoint.closeOnce.Do(func() {
+		close(endpoint.closed)
+	})
+	if endpoint.sockets == nil {
+		return
+	}
+	endpoint.sockets.Lock()
+	defer endpoint.sockets.Unlock()
+	for conn := range endpoint.sockets.conns {
+		conn.Close()
+	}
+}
+
+// serve relays each message received on the given connection to all other connections in the group.
+func (group *socketGroup) serve(ws *websocket.Conn) {
+	queue := make(chan socketMessage, socketQueueSize)
+	group.Lock()
+	group.conns[ws] = queue
+	group.Unlock()
+
+	defer func() {
+		group.Lock()
+		delete(group.conns, ws)
+		close(queue)
+		group.Unlock()
+		ws.Close()
+	}()
+
+	// Send queued messages separately so a slow connection does not hold up the others
+	go func() {
+		for message := range queue {
+			ws.PayloadType = message.payloadType
+			if _, err := ws.Write(message.data); err != nil {
+				ws.Close()
+				return
+			}
+		}
+	}()
+
+	for {
+		var message []byte
+		frame, err := ws.NewFrameReader()
+		if err == nil {
+			message, err = readFrame(frame)
+		}
+		if err != nil {
+			return
+		}
+
+		group.Lock()
+		for conn, connQueue := range group.conns {
+			if conn == ws {
+				continue
+			}
+			select {
+			case connQueue <- socketMessage{payloadType: frame.PayloadType(), data: message}:
+			default:
+				logger.Devlog("Dropped a broadcast message for a connection too slow to receive it")
+			}
+		}
+		group.Unlock()
+	}
+}
+
+func serveEchoSocket(ws *websocket.Conn) {
+	defer ws.Close()
+	for {
+		frame, err := ws.NewFrameReader()
+		if err != nil {
+			return
+		}
+		message, err := readFrame(frame)
+		if err != nil {
+			return
+		}
+		ws.PayloadType = frame.PayloadType()
+		ws.Write(message)
+	}
+}
+
+func readFrame(frame io.Reader) ([]byte, error) {
+	var buffer bytes.Buffer
+	_, err := buffer.ReadFrom(frame)
+	return buffer.Bytes(), err
+}
+
+// serveEventStream replays the events from the endpoint file as Server-Sent Events,
+// keeping the connection open once done so browsers do not reconnect and replay.
+func (fs *FileServer) serveEventStream(w http.ResponseWriter, r *http.Request, endpoint *RealtimeEndpoint) {
+	fPath, err := fs.streamFilePath(endpoint)
+	if err == nil {
+		var events []*StreamEvent
+		events, err = readStreamEvents(fPath)
+		if err == nil {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			controller := http.NewResponseController(w)
+			controller.Flush()
+
+			// End the stream when the client disconnects or the endpoint is removed
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+			go func() {
+				select {
+				case <-endpoint.closed:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			for {
+				started := time.Now()
+				if !sendStreamEvents(ctx, w, controller, events) || !endpoint.Loop || len(events) == 0 {
+					break
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(minStreamLoopTime - time.Since(started)):
+				}
+			}
+			<-ctx.Done()
+			return
+		}
+	}
+
+	logger.Error("Loading event stream "+endpoint.File, err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// sendStreamEvents writes the given events in order, returning false if the client disconnected.
+func sendStreamEvents(ctx context.Context, w http.ResponseWriter, controller *http.ResponseController, events []*StreamEvent) bool {
+	for _, event := range events {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Duration(event.Delay) * time.Millisecond):
+		}
+
+		if event.ID != "" {
+			fmt.Fprintf(w, "id: %s\n", event.ID)
+		}
+		if event.Event != "" {
+			fmt.Fprintf(w, "event: %s\n", event.Event)
+		}
+		for _, line := range strings.Split(lineBreakReplacer.Replace(event.dataString()), "\n") {
+			fmt.Fprintf(w, "data: %s\n", line)
+		}
+		fmt.Fprint(w, "\n")
+
+		if err := controller.Flush(); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// dataString provides the event data, with JSON strings unquoted so they are sent as-is.
+func (event *StreamEvent) dataString() string {
+	var text string
+	if err := json.Unmarshal(event.Data, &text); err == nil {
+		return text
+	}
+	return string(event.Data)
+}
+
+// readStreamEvents loads the events from either a JSON array or newline delimited JSON file.
+func readStreamEvents(fPath string) ([]*StreamEvent, error) {
+	data, err := os.ReadFile(fPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*StreamEvent
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &events); err != nil {
+			return nil, err
+		}
+		return events, validateStreamEvents(events)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		event := new(StreamEvent)
+		if err := json.Unmarshal(text, event); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, validateStreamEvents(events)
+}
+
+// lineBreakReplacer normalises the line breaks of event data so each line is sent as its own data field.
+var lineBreakReplacer = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// validateStreamEvents checks event names and ids are single lines, since
+// line breaks within them would end the field and alter the stream.
+func validateStreamEvents(events []*StreamEvent) error {
+	for index, event := range events {
+		if strings.ContainsAny(event.Event, "\r\n") || strings.ContainsAny(event.ID, "\r\n") {
+			return fmt.Errorf("event %d: event and id must not contain line breaks", index+1)
+		}
+	}
+	return nil
+}
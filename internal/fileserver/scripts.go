@@ -0,0 +1,279 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cgi"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ssddanbrown/webby/internal/fastcgi"
+	"github.com/ssddanbrown/webby/internal/logger"
+)
+
+// ScriptHandler runs files with a given extension as CGI scripts, either through
+// a CGI interpreter or by passing them to a FastCGI application.
+// Script handlers can only be set from a project's config file, since they run programs.
+type ScriptHandler struct {
+	Extension string `json:"extension"`
+	CGI       string `json:"cgi,omitempty"`
+	FastCGI   string `json:"fastcgi,omitempty"`
+}
+
+// AddScriptHandler adds, or replaces the existing handler for, a script file extension.
+func (fs *FileServer) AddScriptHandler(handler *ScriptHandler) error {
+	if !strings.HasPrefix(handler.Extension, ".") || len(handler.Extension) < 2 {
+		return fmt.Errorf("script extension %q must start with a .", handler.Extension)
+	}
+	if (handler.CGI == "") == (handler.FastCGI == "") {
+		return fmt.Errorf("script handler for %s must use either a CGI interpreter or FastCGI address", handler.Extension)
+	}
+	if extension := strings.ToLower(handler.Extension); extension == ".html" || extension == ".htm" {
+		return fmt.Errorf("script extension %q cannot be used for HTML files", handler.Extension)
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	for index, existing := range fs.ScriptHandlers {
+		if strings.EqualFold(existing.Extension, handler.Extension) {
+			fs.ScriptHandlers[index] = handler
+			return nil
+		}
+	}
+	fs.ScriptHandlers = append(fs.ScriptHandlers, handler)
+	return nil
+}
+
+// RemoveScriptHandler removes the script handler at the given index from the server.
+func (fs *FileServer) RemoveScriptHandler(index int) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if index < 0 || index >= len(fs.ScriptHandlers) {
+		return fmt.Errorf("script handler %d not found", index)
+	}
+	fs.ScriptHandlers = append(fs.ScriptHandlers[:index], fs.ScriptHandlers[index+1:]...)
+	return nil
+}
+
+// matchScript finds the script handler, and script file, for the given file path.
+// Folders are checked for an index script matching any of the handled extensions.
+func (fs *FileServer) matchScript(fPath string) (*ScriptHandler, string) {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	if len(fs.ScriptHandlers) == 0 {
+		return nil, ""
+	}
+
+	stat, err := os.Stat(fPath)
+	if err != nil {
+		return nil, ""
+	}
+
+	for _, handler := range fs.ScriptHandlers {
+		scriptPath := fPath
+		if stat.IsDir() {
+			scriptPath = filepath.Join(fPath, "index"+handler.Extension)
+			if indexStat, err := os.Stat(scriptPath); err != nil || indexStat.IsDir() {
+				continue
+			}
+		}
+		if strings.EqualFold(filepath.Ext(scriptPath), handler.Extension) {
+			return handler, scriptPath
+		}
+	}
+	return nil, ""
+}
+
+// serveScript runs the given script file using its handler, injecting
+// the live reload script into any HTML output.
+func (fs *FileServer) serveScript(w http.ResponseWriter, r *http.Request, handler *ScriptHandler, scriptPath string) {
+	if fs.liveReloadEnabled() {
+		iw := &injectingResponseWriter{ResponseWriter: w, scriptTag: fs.liveReloadScriptTag(r.Host)}
+		defer iw.Close()
+		w = iw
+	}
+
+	scriptName := "/" + filepath.ToSlash(strings.TrimPrefix(scriptPath, fs.RootPath+string(filepath.Separator)))
+	env := fs.scriptEnv(r, scriptPath, scriptName)
+
+	if handler.FastCGI != "" {
+		err := serveFastCGI(w, r, handler.FastCGI, env)
+		if err != nil {
+			fs.logFor(r).Error("Running FastCGI script "+scriptName, err)
+			http.Error(w, "Webby could not run "+scriptName+" via FastCGI: "+err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+
+	cgiHandler := &cgi.Handler{
+		Path: handler.CGI,
+		Args: []string{scriptPath},
+		Dir:  filepath.Dir(scriptPath),
+		Root: r.URL.Path,
+	}
+	for name, value := range env {
+		cgiHandler.Env = append(cgiHandler.Env, name+"="+value)
+	}
+	cgiHandler.ServeHTTP(w, r)
+}
+
+// scriptEnv builds the CGI environment variables for running the given script.
+func (fs *FileServer) scriptEnv(r *http.Request, scriptPath string, scriptName string) map[string]string {
+	env := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "webby",
+		"SERVER_PROTOCOL":   r.Proto,
+		"SERVER_NAME":       r.Host,
+		"SERVER_PORT":       strconv.Itoa(fs.Port),
+		"REQUEST_METHOD":    r.Method,
+		"REQUEST_URI":       r.URL.RequestURI(),
+		"REQUEST_SCHEME":    fs.Scheme(),
+		"QUERY_STRING":      r.URL.RawQuery,
+		"SCRIPT_NAME":       scriptName,
+		"SCRIPT_FILENAME":   scriptPath,
+		"DOCUMENT_ROOT":     fs.RootPath,
+		"PATH_INFO":         "",
+		"REDIRECT_STATUS":   "200",
+		"REMOTE_ADDR":       r.RemoteAddr,
+		"CONTENT_TYPE":      r.Header.Get("Content-Type"),
+	}
+
+	if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		env["REMOTE_ADDR"] = host
+		env["REMOTE_PORT"] = port
+	}
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		env["SERVER_NAME"] = host
+	}
+	if r.ContentLength > 0 {
+		env["CONTENT_LENGTH"] = strconv.FormatInt(r.ContentLength, 10)
+	}
+	if fs.HTTPS {
+		env["HTTPS"] = "on"
+	}
+
+	for name, values := range r.Header {
+		key := "HTTP_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+		if key == "HTTP_PROXY" {
+			continue
+		}
+		env[key] = strings.Join(values, ", ")
+	}
+
+	return env
+}
+
+// serveFastCGI sends the request to the FastCGI application at the given
+// address, which may be a "unix:" prefixed socket path, and writes its response.
+func serveFastCGI(w http.ResponseWriter, r *http.Request, address string, env map[string]string) error {
+	network := "tcp"
+	if strings.HasPrefix(address, "unix:") {
+		network = "unix"
+		address = strings.TrimPrefix(address, "unix:")
+	}
+
+	response, err := fastcgi.Do(network, address, env, r.Body)
+	if err != nil {
+		return err
+	}
+	if len(response.Stderr) > 0 {
+		logger.Devlog("FastCGI: " + strings.TrimSpace(string(response.Stderr)))
+	}
+
+	return writeCGIResponse(w, response.Stdout)
+}
+
+// writeCGIResponse parses the headers from the given CGI script output and writes the response.
+func writeCGIResponse(w http.ResponseWriter, output []byte) error {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(output)))
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if len(header) == 0 {
+		return errors.New("script returned no headers")
+	}
+
+	status := http.StatusOK
+	if statusHeader := header.Get("Status"); statusHeader != "" {
+		status, err = strconv.Atoi(strings.SplitN(statusHeader, " ", 2)[0])
+		if err != nil || !isValidStatus(status) {
+			return fmt.Errorf("invalid status header %q", statusHeader)
+		}
+		header.Del("Status")
+	} else if header.Get("Location") != "" {
+		status = http.StatusFound
+	}
+
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(status)
+	_, err = io.Copy(w, reader.R)
+	return err
+}
+
+// injectingResponseWriter buffers HTML responses so the live
+// reload script can be injected once the response is complete.
+type injectingResponseWriter struct {
+	http.ResponseWriter
+	scriptTag   string
+	status      int
+	wroteHeader bool
+	buffer      *bytes.Buffer
+}
+
+func (iw *injectingResponseWriter) WriteHeader(status int) {
+	if iw.wroteHeader {
+		return
+	}
+	iw.wroteHeader = true
+
+	contentType := iw.Header().Get("Content-Type")
+	if strings.HasPrefix(contentType, "text/html") && status == http.StatusOK {
+		iw.status = status
+		iw.buffer = new(bytes.Buffer)
+		iw.Header().Del("Content-Length")
+		return
+	}
+	iw.ResponseWriter.WriteHeader(status)
+}
+
+func (iw *injectingResponseWriter) Write(data []byte) (int, error) {
+	if !iw.wroteHeader {
+		if iw.Header().Get("Content-Type") == "" {
+			iw.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		iw.WriteHeader(http.StatusOK)
+	}
+	if iw.buffer != nil {
+		return iw.buffer.Write(data)
+	}
+	return iw.ResponseWriter.Write(data)
+}
+
+// Close writes any buffered HTML response with the live reload script injected.
+func (iw *injectingResponseWriter) Close() {
+	if iw.buffer == nil {
+		return
+	}
+	iw.ResponseWriter.WriteHeader(iw.status)
+	iw.ResponseWriter.Write(injectScript(iw.buffer.Bytes(), iw.scriptTag))
+	iw.buffer = nil
+}
+
+// Unwrap provides the underlying response writer for use by http.ResponseController.
+func (iw *injectingResponseWriter) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
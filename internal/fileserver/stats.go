@@ -0,0 +1,78 @@
+package fileserver
+
+import (
+	"sync"
+	"time"
+)
+
+// ServerStats holds the usage statistics of a server since it was started.
+type ServerStats struct {
+	Requests          int64         `json:"requests"`
+	BytesServed       int64         `json:"bytes_served"`
+	StatusCodes       map[int]int64 `json:"status_codes"`
+	LastAccess        time.Time     `json:"last_access"`
+	ActiveRequests    int           `json:"active_requests"`
+	LiveReloadClients int           `json:"livereload_clients"`
+}
+
+// serverStats guards the statistics of a server as they are updated by concurrent requests.
+type serverStats struct {
+	sync.Mutex
+	ServerStats
+	lastActivity time.Time
+}
+
+// LastAccessAgo provides how long ago the server was last requested, for display.
+func (stats ServerStats) LastAccessAgo() string {
+	if stats.LastAccess.IsZero() {
+		return "never"
+	}
+	return time.Since(stats.LastAccess).Round(time.Second).String() + " ago"
+}
+
+// Stats provides a copy of the current usage statistics of the server.
+func (fs *FileServer) Stats() ServerStats {
+	fs.stats.Lock()
+	defer fs.stats.Unlock()
+
+	stats := fs.stats.ServerStats
+	stats.StatusCodes = make(map[int]int64, len(fs.stats.StatusCodes))
+	for status, count := range fs.stats.StatusCodes {
+		stats.StatusCodes[status] = count
+	}
+	return stats
+}
+
+// AddLiveReloadClient changes the count of live reload clients connected from pages on the server.
+func (fs *FileServer) AddLiveReloadClient(delta int) {
+	fs.stats.Lock()
+	fs.stats.LiveReloadClients += delta
+	fs.stats.lastActivity = time.Now()
+	fs.stats.Unlock()
+}
+
+// startRequest counts a request as active until it is counted as served.
+func (fs *FileServer) startRequest() {
+	fs.stats.Lock()
+	fs.stats.ActiveRequests++
+	fs.stats.lastActivity = time.Now()
+	fs.stats.Unlock()
+}
+
+// countRequest adds a served request to the usage statistics.
+func (fs *FileServer) countRequest(record *RequestRecord) {
+	fs.stats.Lock()
+	defer fs.stats.Unlock()
+
+	fs.stats.ActiveRequests--
+	fs.stats.lastActivity = time.Now()
+	if fs.stats.StatusCodes == nil {
+		fs.stats.StatusCodes = make(map[int]int64)
+	}
+	fs.stats.Requests++
+	fs.stats.BytesServed += record.Size
+	if record.Status != 0 {
+		fs.stats.StatusCodes[record.Status]++
+	}
+	fs.stats.LastAccess = record.Started
+}
@@ -0,0 +1,212 @@
+package fileserver
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// throttleTick is how often a chunk of a throttled response is sent.
+const throttleTick = 100 * time.Millisecond
+
+// ThrottleProfile sets the simulated network conditions of a connection.
+type ThrottleProfile struct {
+	Latency   int `json:"latency_ms"`
+	Bandwidth int `json:"bandwidth_kbps"`
+	Jitter    int `json:"jitter_ms"`
+}
+
+// ThrottleProfiles are the named network conditions that can be selected for a server.
+var ThrottleProfiles = map[string]ThrottleProfile{
+	"slow-3g": {Latency: 2000, Bandwidth: 400, Jitter: 300},
+	"3g":      {Latency: 560, Bandwidth: 1600, Jitter: 150},
+	"slow-4g": {Latency: 170, Bandwidth: 4000, Jitter: 50},
+	"4g":      {Latency: 85, Bandwidth: 9000, Jitter: 20},
+}
+
+// ThrottleConfig sets the network conditions simulated for responses, optionally limited
+// to request paths matching a glob or to responses of the given content types.
+type ThrottleConfig struct {
+	Profile      string   `json:"profile"`
+	Latency      int      `json:"latency_ms,omitempty"`
+	Bandwidth    int      `json:"bandwidth_kbps,omitempty"`
+	Jitter       int      `json:"jitter_ms,omitempty"`
+	Paths        []string `json:"paths,omitempty"`
+	ContentTypes []string `json:"content_types,omitempty"`
+}
+
+// SetThrottle sets the network conditions simulated for the server, or disables
+// throttling if nil. Values not given for a named profile are taken from that profile.
+func (fs *FileServer) SetThrottle(config *ThrottleConfig) error {
+	if config != nil {
+		if config.Profile == "" {
+			config.Profile = "custom"
+		}
+		if _, exists := ThrottleProfiles[config.Profile]; !exists && config.Profile != "custom" {
+			return fmt.Errorf("unknown throttle profile %q", config.Profile)
+		}
+		if config.Latency < 0 || config.Bandwidth < 0 || config.Jitter < 0 {
+			return fmt.Errorf("throttle latency, bandwidth and jitter cannot be negative")
+		}
+		for _, pattern := range config.Paths {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid throttle path glob %q", pattern)
+			}
+		}
+	}
+
+	fs.mutex.Lock()
+	fs.Throttle = config
+	fs.mutex.Unlock()
+	return nil
+}
+
+// conditions provides the network conditions to simulate, using the values of
+// the named profile for any not set in the config.
+func (config *ThrottleConfig) conditions() ThrottleProfile {
+	conditions := ThrottleProfiles[config.Profile]
+	if config.Latency != 0 {
+		conditions.Latency = config.Latency
+	}
+	if config.Bandwidth != 0 {
+		conditions.Bandwidth = config.Bandwidth
+	}
+	if config.Jitter != 0 {
+		conditions.Jitter = config.Jitter
+	}
+	return conditions
+}
+
+// matchThrottle provides the throttle config to apply to the given request path, if any.
+func (fs *FileServer) matchThrottle(requestPath string) *ThrottleConfig {
+	fs.mutex.RLock()
+	config := fs.Throttle
+	fs.mutex.RUnlock()
+
+	if config == nil || len(config.Paths) == 0 {
+		return config
+	}
+	for _, pattern := range config.Paths {
+		if matchPathGlob(pattern, requestPath) {
+			return config
+		}
+	}
+	return nil
+}
+
+// matchPathGlob checks if the given request path matches a glob pattern. Patterns without
+// a slash, such as *.js, match the file name in any folder while patterns ending in a
+// slash match everything under that folder.
+func matchPathGlob(pattern string, requestPath string) bool {
+	target := requestPath
+	if !strings.Contains(pattern, "/") {
+		target = path.Base(requestPath)
+	}
+	if matched, _ := path.Match(pattern, target); matched {
+		return true
+	}
+	return strings.HasSuffix(pattern, "/") && strings.HasPrefix(requestPath, pattern)
+}
+
+// matchesContentType checks if responses of the given content type should be throttled.
+func (config *ThrottleConfig) matchesContentType(contentType string) bool {
+	if len(config.ContentTypes) == 0 {
+		return true
+	}
+	for _, prefix := range config.ContentTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// throttleResponseWriter delays the start of a response by the configured latency
+// and then trickles the body out at the configured bandwidth.
+type throttleResponseWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	config      *ThrottleConfig
+	conditions  ThrottleProfile
+	wroteHeader bool
+	throttled   bool
+	chunkSize   int
+}
+
+func newThrottleResponseWriter(w http.ResponseWriter, r *http.Request, config *ThrottleConfig) *throttleResponseWriter {
+	return &throttleResponseWriter{ResponseWriter: w, ctx: r.Context(), config: config, conditions: config.conditions()}
+}
+
+// wait pauses for the given duration, returning false early if the client goes away.
+func (tw *throttleResponseWriter) wait(duration time.Duration) bool {
+	select {
+	case <-time.After(duration):
+		return true
+	case <-tw.ctx.Done():
+		return false
+	}
+}
+
+func (tw *throttleResponseWriter) WriteHeader(status int) {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+
+	if tw.config.matchesContentType(tw.Header().Get("Content-Type")) {
+		tw.throttled = true
+		latency := time.Duration(tw.conditions.Latency) * time.Millisecond
+		if tw.conditions.Jitter > 0 {
+			latency += time.Duration(rand.Intn(2*tw.conditions.Jitter+1)-tw.conditions.Jitter) * time.Millisecond
+		}
+		if latency > 0 {
+			tw.wait(latency)
+		}
+
+		// Send bandwidth worth of kilobits per second, as bytes per tick
+		tw.chunkSize = tw.conditions.Bandwidth * 1000 / 8 / int(time.Second/throttleTick)
+	}
+
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *throttleResponseWriter) Write(data []byte) (int, error) {
+	if !tw.wroteHeader {
+		if tw.Header().Get("Content-Type") == "" {
+			tw.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		tw.WriteHeader(http.StatusOK)
+	}
+	if !tw.throttled || tw.chunkSize <= 0 {
+		return tw.ResponseWriter.Write(data)
+	}
+
+	written := 0
+	for written < len(data) {
+		end := written + tw.chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		// Each chunk arrives once the time taken to transfer it has passed
+		if !tw.wait(throttleTick) {
+			return written, tw.ctx.Err()
+		}
+		n, err := tw.ResponseWriter.Write(data[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		http.NewResponseController(tw.ResponseWriter).Flush()
+	}
+	return written, nil
+}
+
+// Unwrap provides the underlying response writer for use by http.ResponseController.
+func (tw *throttleResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
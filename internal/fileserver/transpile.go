@@ -0,0 +1,269 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/ssddanbrown/webby/internal/logger"
+)
+
+// transpileLoaders maps the file extensions transpiled on request to their esbuild loader.
+var transpileLoaders = map[string]api.Loader{
+	".ts":  api.LoaderTS,
+	".mts": api.LoaderTS,
+	".tsx": api.LoaderTSX,
+	".jsx": api.LoaderJSX,
+}
+
+// TranspileConfig sets how TypeScript and JSX files are transpiled, and which
+// entry points, relative to the served folder, are bundled with their imports.
+type TranspileConfig struct {
+	Bundle          []string `json:"bundle"`
+	JSXFactory      string   `json:"jsx_factory,omitempty"`
+	JSXFragment     string   `json:"jsx_fragment,omitempty"`
+	JSXImportSource string   `json:"jsx_import_source,omitempty"`
+}
+
+// CompileError is a single error found while transpiling or bundling a file.
+type CompileError struct {
+	Text     string `json:"text"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	LineText string `json:"line_text,omitempty"`
+}
+
+// compiledScript is transpiled output along with the modification times of its inputs.
+type compiledScript struct {
+	code   []byte
+	inputs map[string]time.Time
+}
+
+// scriptCache holds the transpiled output of a server so files are only recompiled on change.
+type scriptCache struct {
+	sync.Mutex
+	scripts map[string]*compiledScript
+}
+
+// SetTranspile sets the transpile options for the server.
+func (fs *FileServer) SetTranspile(config *TranspileConfig) error {
+	if config != nil {
+		for _, entry := range config.Bundle {
+			if _, err := fs.resolvePagePath(entry, fs.RootPath); err != nil {
+				return fmt.Errorf("bundle entry point %s", err.Error())
+			}
+		}
+	}
+
+	fs.mutex.Lock()
+	fs.Transpile = config
+	fs.mutex.Unlock()
+
+	// Clear previous output since it may have used different options
+	fs.scriptCache.Lock()
+	fs.scriptCache.scripts = nil
+	fs.scriptCache.Unlock()
+	return nil
+}
+
+// matchTranspile checks if the given file should be transpiled, or bundled, when requested.
+func (fs *FileServer) matchTranspile(fPath string) (transpile bool, bundle bool) {
+	fs.mutex.RLock()
+	config := fs.Transpile
+	fs.mutex.RUnlock()
+
+	if config != nil {
+		for _, entry := range config.Bundle {
+			if filepath.Join(fs.RootPath, filepath.FromSlash(entry)) == fPath {
+				return true, true
+			}
+		}
+	}
+
+	_, exists := transpileLoaders[strings.ToLower(filepath.Ext(fPath))]
+	return exists, false
+}
+
+// serveTranspiled transpiles, or bundles, the given file to JavaScript. Compile errors are
+// sent to open pages, to be shown as an overlay, and returned as a script that shows the overlay.
+// That script is sent with a 200 status, since browsers will not run scripts with error statuses,
+// along with a X-Webby-Compile-Error header naming the file.
+func (fs *FileServer) serveTranspiled(w http.ResponseWriter, r *http.Request, fPath string, bundle bool) {
+	relPath, _ := filepath.Rel(fs.RootPath, fPath)
+	relPath = filepath.ToSlash(relPath)
+
+	var code []byte
+	var compileErrors []CompileError
+	if bundle {
+		code, compileErrors = fs.bundle(fPath)
+	} else {
+		code, compileErrors = fs.transpile(fPath, relPath)
+	}
+
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	if len(compileErrors) == 0 {
+		w.Write(code)
+		return
+	}
+
+	fs.logFor(r).Error("Compiling "+relPath, errors.New(compileErrors[0].Text))
+	detail := map[string]interface{}{"file": relPath, "errors": compileErrors}
+	fs.sendEvent("compile-error", detail)
+
+	detailJSON, _ := json.Marshal(detail)
+	w.Header().Set("X-Webby-Compile-Error", relPath)
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintf(w, "document.dispatchEvent(new CustomEvent('webby:compile-error', {detail: %s}));\n", detailJSON)
+	fmt.Fprintf(w, "throw new Error(%q);\n", "Webby could not compile "+relPath+": "+compileErrors[0].Text)
+}
+
+func (fs *FileServer) transpile(fPath string, relPath string) ([]byte, []CompileError) {
+	stat, err := os.Stat(fPath)
+	if err != nil {
+		return nil, []CompileError{{Text: err.Error(), File: relPath}}
+	}
+
+	// Watch the folder of the file so changes reload the page
+	fs.recordDependencies(fPath, map[string]bool{fPath: true})
+
+	if code, ok := fs.cachedScript(fPath); ok {
+		return code, nil
+	}
+
+	source, err := os.ReadFile(fPath)
+	if err != nil {
+		return nil, []CompileError{{Text: err.Error(), File: relPath}}
+	}
+
+	options := api.TransformOptions{
+		Loader:     transpileLoaders[strings.ToLower(filepath.Ext(fPath))],
+		Sourcefile: "/" + relPath,
+		Sourcemap:  api.SourceMapInline,
+		Target:     api.ESNext,
+		LogLevel:   api.LogLevelSilent,
+	}
+	fs.applyJSXOptions(&options.JSX, &options.JSXFactory, &options.JSXFragment, &options.JSXImportSource)
+
+	result := api.Transform(string(source), options)
+	if len(result.Errors) > 0 {
+		return nil, toCompileErrors(result.Errors)
+	}
+
+	logger.Devlog("Transpiled " + relPath)
+	fs.storeScript(fPath, result.Code, map[string]time.Time{fPath: stat.ModTime()})
+	return result.Code, nil
+}
+
+func (fs *FileServer) bundle(fPath string) ([]byte, []CompileError) {
+	if code, ok := fs.cachedScript(fPath); ok {
+		return code, nil
+	}
+
+	options := api.BuildOptions{
+		EntryPoints:   []string{fPath},
+		AbsWorkingDir: fs.RootPath,
+		Bundle:        true,
+		Write:         false,
+		Metafile:      true,
+		Format:        api.FormatESModule,
+		Sourcemap:     api.SourceMapInline,
+		Target:        api.ESNext,
+		LogLevel:      api.LogLevelSilent,
+	}
+	fs.applyJSXOptions(&options.JSX, &options.JSXFactory, &options.JSXFragment, &options.JSXImportSource)
+
+	result := api.Build(options)
+	if len(result.Errors) > 0 {
+		return nil, toCompileErrors(result.Errors)
+	}
+	if len(result.OutputFiles) == 0 {
+		return nil, []CompileError{{Text: "bundle produced no output"}}
+	}
+
+	// Track every bundled input so the bundle is rebuilt, and pages reload, when any change
+	var metafile struct {
+		Inputs map[string]interface{} `json:"inputs"`
+	}
+	json.Unmarshal([]byte(result.Metafile), &metafile)
+
+	inputs := make(map[string]time.Time)
+	dependencies := make(map[string]bool)
+	for input := range metafile.Inputs {
+		inputPath := filepath.Join(fs.RootPath, filepath.FromSlash(input))
+		if stat, err := os.Stat(inputPath); err == nil {
+			inputs[inputPath] = stat.ModTime()
+			dependencies[inputPath] = true
+		}
+	}
+	fs.recordDependencies(fPath, dependencies)
+
+	code := result.OutputFiles[0].Contents
+	logger.Devlog(fmt.Sprintf("Bundled %s from %d files", fPath, len(inputs)))
+	fs.storeScript(fPath, code, inputs)
+	return code, nil
+}
+
+func (fs *FileServer) applyJSXOptions(jsx *api.JSX, factory *string, fragment *string, importSource *string) {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	if fs.Transpile == nil {
+		return
+	}
+	*factory = fs.Transpile.JSXFactory
+	*fragment = fs.Transpile.JSXFragment
+	if fs.Transpile.JSXImportSource != "" {
+		*jsx = api.JSXAutomatic
+		*importSource = fs.Transpile.JSXImportSource
+	}
+}
+
+func toCompileErrors(messages []api.Message) []CompileError {
+	var compileErrors []CompileError
+	for _, message := range messages {
+		compileError := CompileError{Text: message.Text}
+		if message.Location != nil {
+			compileError.File = message.Location.File
+			compileError.Line = message.Location.Line
+			compileError.Column = message.Location.Column
+			compileError.LineText = message.Location.LineText
+		}
+		compileErrors = append(compileErrors, compileError)
+	}
+	return compileErrors
+}
+
+// cachedScript provides the cached output for the given file if none of its inputs have changed.
+func (fs *FileServer) cachedScript(fPath string) ([]byte, bool) {
+	fs.scriptCache.Lock()
+	cached, exists := fs.scriptCache.scripts[fPath]
+	fs.scriptCache.Unlock()
+
+	if !exists {
+		return nil, false
+	}
+	for input, modTime := range cached.inputs {
+		stat, err := os.Stat(input)
+		if err != nil || !stat.ModTime().Equal(modTime) {
+			return nil, false
+		}
+	}
+	return cached.code, true
+}
+
+func (fs *FileServer) storeScript(fPath string, code []byte, inputs map[string]time.Time) {
+	fs.scriptCache.Lock()
+	if fs.scriptCache.scripts == nil {
+		fs.scriptCache.scripts = make(map[string]*compiledScript)
+	}
+	fs.scriptCache.scripts[fPath] = &compiledScript{code: code, inputs: inputs}
+	fs.scriptCache.Unlock()
+}
@@ -0,0 +1,471 @@
+package fileserver
+
+import (
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// utilityPrefix is the reserved path under which the built-in developer utility endpoints are served.
+const utilityPrefix = "/__webby/"
+
+// maxUtilityDelay is the longest delay the status endpoint will wait before responding.
+const maxUtilityDelay = 60 * time.Second
+
+// maxFakeRecords is the largest number of records the fake endpoint will generate at once.
+const maxFakeRecords = 1000
+
+var utilityHelp = `Webby utility endpoints:
+
+/__webby/placeholder/{width}x{height}[.png|.svg]?bg=ccc&fg=555&text=Hello
+    Placeholder image of the given size, colours and text
+
+/__webby/lorem/{words|sentences|paragraphs|list}/{count}?format=text|html|json
+    Lorem ipsum text in the given shape
+
+/__webby/fake/{count}?{field}={type}&seed=1
+    Fake JSON records with the given fields. Types: id, uuid, name, first_name,
+    last_name, email, username, word, sentence, paragraph, int, float, bool,
+    date, datetime, url, image, phone, city, country, color or a|b|c choices
+
+/__webby/status/{code}?delay=2s
+    Respond with the given status code after an optional delay
+`
+
+var loremWords = strings.Fields(`lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor
+	incididunt ut labore et dolore magna aliqua enim ad minim veniam quis nostrud exercitation ullamco laboris
+	nisi aliquip ex ea commodo consequat duis aute irure in reprehenderit voluptate velit esse cillum fugiat
+	nulla pariatur excepteur sint occaecat cupidatat non proident sunt culpa qui officia deserunt mollit anim
+	id est laborum`)
+
+var fakeFirstNames = strings.Fields("Alex Bailey Casey Dana Elliot Frankie Harper Jordan Kai Logan Morgan Nico Parker Quinn Riley Sam Taylor")
+var fakeLastNames = strings.Fields("Adams Brooks Carter Diaz Evans Foster Garcia Hughes Ito Khan Lee Morris Nguyen Patel Reid Silva Walsh")
+var fakeCities = strings.Fields("Amsterdam Berlin Cairo Dublin Lagos Lima Lisbon London Madrid Mumbai Osaka Oslo Paris Seoul Sydney Toronto")
+var fakeCountries = strings.Fields("Australia Brazil Canada Egypt France Germany India Ireland Japan Kenya Mexico Netherlands Norway Peru Spain")
+
+// isUtilityPath checks if the given request path is within the reserved utility endpoint path.
+func isUtilityPath(path string) bool {
+	return path+"/" == utilityPrefix || strings.HasPrefix(path, utilityPrefix)
+}
+
+// serveUtility serves the built-in developer utility endpoint for the given request.
+func (fs *FileServer) serveUtility(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, utilityPrefix), "/"), "/")
+	w.Header().Set("Cache-Control", "no-store")
+
+	switch parts[0] {
+	case "placeholder":
+		servePlaceholder(w, r, parts[1:])
+	case "lorem":
+		serveLorem(w, r, parts[1:])
+	case "fake":
+		serveFake(w, r, parts[1:])
+	case "status":
+		serveStatusEcho(w, r, parts[1:])
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if parts[0] != "" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		fmt.Fprint(w, utilityHelp)
+	}
+}
+
+// servePlaceholder serves a placeholder image, as PNG or SVG, sized using a "{width}x{height}" path part.
+func servePlaceholder(w http.ResponseWriter, r *http.Request, parts []string) {
+	if len(parts) != 1 {
+		http.Error(w, "placeholder size must be given as /__webby/placeholder/{width}x{height}", http.StatusBadRequest)
+		return
+	}
+
+	size, format := parts[0], "png"
+	if dot := strings.LastIndex(size, "."); dot != -1 {
+		size, format = size[:dot], strings.ToLower(size[dot+1:])
+	}
+
+	dimensions := strings.SplitN(size, "x", 2)
+	width, widthErr := strconv.Atoi(dimensions[0])
+	height, heightErr := width, error(nil)
+	if len(dimensions) == 2 {
+		height, heightErr = strconv.Atoi(dimensions[1])
+	}
+	if widthErr != nil || heightErr != nil || width < 1 || height < 1 || width > maxImageSize || height > maxImageSize {
+		http.Error(w, fmt.Sprintf("placeholder size must be between 1x1 and %dx%d", maxImageSize, maxImageSize), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	background, err := parseHexColor(query.Get("bg"), color.RGBA{0xCC, 0xCC, 0xCC, 0xFF})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	foreground, err := parseHexColor(query.Get("fg"), color.RGBA{0x55, 0x55, 0x55, 0xFF})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	text := query.Get("text")
+	if text == "" {
+		text = fmt.Sprintf("%dx%d", width, height)
+	}
+
+	switch format {
+	case "png":
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, placeholderImage(width, height, background, foreground, text))
+	case "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, width, height, width, height)
+		fmt.Fprintf(w, `<rect width="100%%" height="100%%" fill="%s"/>`, hexColor(background))
+		fmt.Fprintf(w, `<text x="50%%" y="50%%" fill="%s" font-family="sans-serif" font-size="%d" text-anchor="middle" dominant-baseline="central">%s</text></svg>`,
+			hexColor(foreground), atLeastOne(minInt(width, height)/8), html.EscapeString(text))
+	default:
+		http.Error(w, fmt.Sprintf("unknown placeholder format %q, expected png or svg", format), http.StatusBadRequest)
+	}
+}
+
+// placeholderImage draws a solid image with the given text centered upon it, scaling
+// the built-in bitmap font up to fit larger images.
+func placeholderImage(width int, height int, background color.RGBA, foreground color.RGBA, text string) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	face := basicfont.Face7x13
+	textWidth := font.MeasureString(face, text).Ceil()
+	textHeight := face.Height
+	if textWidth == 0 {
+		return img
+	}
+
+	label := image.NewRGBA(image.Rect(0, 0, textWidth, textHeight))
+	drawer := &font.Drawer{Dst: label, Src: image.NewUniform(foreground), Face: face, Dot: fixed.P(0, face.Ascent)}
+	drawer.DrawString(text)
+
+	scale := minInt(width*3/4/textWidth, height/3/textHeight)
+	if scale < 1 {
+		scale = 1
+	}
+	scaledWidth, scaledHeight := textWidth*scale, textHeight*scale
+	x, y := (width-scaledWidth)/2, (height-scaledHeight)/2
+	draw.NearestNeighbor.Scale(img, image.Rect(x, y, x+scaledWidth, y+scaledHeight), label, label.Bounds(), draw.Over, nil)
+	return img
+}
+
+// parseHexColor parses a CSS style hex colour, with or without a leading hash,
+// in three or six digit form, returning the fallback if the value is empty.
+func parseHexColor(value string, fallback color.RGBA) (color.RGBA, error) {
+	value = strings.TrimPrefix(value, "#")
+	if value == "" {
+		return fallback, nil
+	}
+	if len(value) == 3 {
+		value = string([]byte{value[0], value[0], value[1], value[1], value[2], value[2]})
+	}
+
+	number, err := strconv.ParseUint(value, 16, 32)
+	if len(value) != 6 || err != nil {
+		return fallback, fmt.Errorf("invalid colour %q, expected a hex colour such as ccc or 1e90ff", value)
+	}
+	return color.RGBA{uint8(number >> 16), uint8(number >> 8), uint8(number), 0xFF}, nil
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// serveLorem serves lorem ipsum text shaped as a number of words, sentences, paragraphs
+// or list items, as plain text, HTML or a JSON array.
+func serveLorem(w http.ResponseWriter, r *http.Request, parts []string) {
+	shape, count := "paragraphs", 3
+	if len(parts) > 0 && parts[0] != "" {
+		shape = parts[0]
+	}
+	if len(parts) > 1 {
+		number, err := strconv.Atoi(parts[1])
+		if err != nil || number < 1 || number > maxFakeRecords {
+			http.Error(w, fmt.Sprintf("lorem count must be a number between 1 and %d", maxFakeRecords), http.StatusBadRequest)
+			return
+		}
+		count = number
+	}
+
+	random := newUtilityRandom(r)
+	var items []string
+	switch shape {
+	case "words":
+		items = []string{strings.Join(loremSequence(random, count), " ")}
+	case "sentences":
+		items = []string{loremSentences(random, count)}
+	case "paragraphs":
+		for i := 0; i < count; i++ {
+			items = append(items, loremSentences(random, 4+random.Intn(4)))
+		}
+	case "list":
+		for i := 0; i < count; i++ {
+			items = append(items, loremSentence(random))
+		}
+	default:
+		http.Error(w, fmt.Sprintf("unknown lorem shape %q, expected words, sentences, paragraphs or list", shape), http.StatusBadRequest)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "json":
+		writeMockJSON(w, http.StatusOK, items)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		tag := "p"
+		if shape == "list" {
+			tag = "li"
+			fmt.Fprint(w, "<ul>\n")
+		}
+		for _, item := range items {
+			fmt.Fprintf(w, "<%s>%s</%s>\n", tag, html.EscapeString(item), tag)
+		}
+		if shape == "list" {
+			fmt.Fprint(w, "</ul>\n")
+		}
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		separator := "\n\n"
+		if shape == "list" {
+			separator = "\n"
+		}
+		fmt.Fprint(w, strings.Join(items, separator)+"\n")
+	}
+}
+
+// loremSequence provides the given number of lorem ipsum words, starting with the classic opening.
+func loremSequence(random *rand.Rand, count int) []string {
+	words := make([]string, count)
+	for i := range words {
+		if i < 5 {
+			words[i] = loremWords[i]
+		} else {
+			words[i] = loremWords[random.Intn(len(loremWords))]
+		}
+	}
+	return words
+}
+
+func loremSentences(random *rand.Rand, count int) string {
+	sentences := make([]string, count)
+	for i := range sentences {
+		sentences[i] = loremSentence(random)
+	}
+	return strings.Join(sentences, " ")
+}
+
+func loremSentence(random *rand.Rand) string {
+	words := make([]string, 6+random.Intn(9))
+	for i := range words {
+		words[i] = loremWords[random.Intn(len(loremWords))]
+	}
+	words[0] = strings.ToUpper(words[0][:1]) + words[0][1:]
+	return strings.Join(words, " ") + "."
+}
+
+// serveFake serves a JSON array of fake records, with fields and types taken from the query
+// parameters. A seed parameter can be given to generate the same records on every request.
+func serveFake(w http.ResponseWriter, r *http.Request, parts []string) {
+	count := 10
+	if len(parts) > 0 && parts[0] != "" {
+		number, err := strconv.Atoi(parts[0])
+		if err != nil || number < 1 || number > maxFakeRecords {
+			writeMockError(w, http.StatusBadRequest, fmt.Sprintf("fake record count must be a number between 1 and %d", maxFakeRecords))
+			return
+		}
+		count = number
+	}
+
+	schema := make(map[string]string)
+	for field, values := range r.URL.Query() {
+		if field != "seed" && len(values) > 0 {
+			schema[field] = values[0]
+		}
+	}
+	if len(schema) == 0 {
+		schema = map[string]string{"id": "id", "name": "name", "email": "email"}
+	}
+
+	// Generate fields in a fixed order so the same seed always gives the same records
+	fields := make([]string, 0, len(schema))
+	for field := range schema {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	random := newUtilityRandom(r)
+	records := make([]mockRecord, count)
+	for i := range records {
+		person := fakePerson{first: pick(random, fakeFirstNames), last: pick(random, fakeLastNames)}
+		record := make(mockRecord)
+		for _, field := range fields {
+			value, err := fakeValue(random, schema[field], i+1, person)
+			if err != nil {
+				writeMockError(w, http.StatusBadRequest, fmt.Sprintf("field %s: %s", field, err.Error()))
+				return
+			}
+			record[field] = value
+		}
+		records[i] = record
+	}
+
+	writeMockJSON(w, http.StatusOK, records)
+}
+
+// fakePerson keeps the name based fields of a fake record consistent with each other.
+type fakePerson struct {
+	first string
+	last  string
+}
+
+// fakeValue generates a value of the given schema type. Types containing a "|" pick one of the given choices.
+func fakeValue(random *rand.Rand, fieldType string, index int, person fakePerson) (interface{}, error) {
+	if strings.Contains(fieldType, "|") {
+		return pick(random, strings.Split(fieldType, "|")), nil
+	}
+
+	username := strings.ToLower(person.first + "." + person.last)
+	switch fieldType {
+	case "id":
+		return index, nil
+	case "uuid":
+		bytes := make([]byte, 16)
+		random.Read(bytes)
+		bytes[6] = bytes[6]&0x0f | 0x40
+		bytes[8] = bytes[8]&0x3f | 0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", bytes[0:4], bytes[4:6], bytes[6:8], bytes[8:10], bytes[10:]), nil
+	case "name":
+		return person.first + " " + person.last, nil
+	case "first_name":
+		return person.first, nil
+	case "last_name":
+		return person.last, nil
+	case "email":
+		return username + "@example.com", nil
+	case "username":
+		return username, nil
+	case "word":
+		return pick(random, loremWords), nil
+	case "sentence":
+		return loremSentence(random), nil
+	case "paragraph":
+		return loremSentences(random, 4+random.Intn(4)), nil
+	case "int":
+		return random.Intn(1000), nil
+	case "float":
+		return float64(random.Intn(100000)) / 100, nil
+	case "bool":
+		return random.Intn(2) == 1, nil
+	case "date", "datetime":
+		date := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(random.Int63n(int64(5 * 365 * 24 * time.Hour))))
+		if fieldType == "date" {
+			return date.Format("2006-01-02"), nil
+		}
+		return date.Format(time.RFC3339), nil
+	case "url":
+		return "https://example.com/" + pick(random, loremWords) + "/" + strconv.Itoa(index), nil
+	case "image":
+		return fmt.Sprintf("%splaceholder/%dx%d", utilityPrefix, 200+random.Intn(5)*100, 200+random.Intn(3)*100), nil
+	case "phone":
+		return fmt.Sprintf("+1 555 %03d %04d", random.Intn(1000), random.Intn(10000)), nil
+	case "city":
+		return pick(random, fakeCities), nil
+	case "country":
+		return pick(random, fakeCountries), nil
+	case "color":
+		return fmt.Sprintf("#%06x", random.Intn(0x1000000)), nil
+	}
+	return nil, fmt.Errorf("unknown fake type %q", fieldType)
+}
+
+func pick(random *rand.Rand, options []string) string {
+	return options[random.Intn(len(options))]
+}
+
+// newUtilityRandom provides a random source, seeded from the seed query parameter if given.
+func newUtilityRandom(r *http.Request) *rand.Rand {
+	seed, err := strconv.ParseInt(r.URL.Query().Get("seed"), 10, 64)
+	if err != nil {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+// serveStatusEcho responds with the status code given in the path, after waiting
+// for the delay given in the query, so error and loading states can be tested.
+func serveStatusEcho(w http.ResponseWriter, r *http.Request, parts []string) {
+	status := http.StatusOK
+	if len(parts) > 0 && parts[0] != "" {
+		number, err := strconv.Atoi(parts[0])
+		if err != nil || number < 200 || number > 599 {
+			writeMockError(w, http.StatusBadRequest, "status code must be a number between 200 and 599")
+			return
+		}
+		status = number
+	}
+
+	var delay time.Duration
+	if value := r.URL.Query().Get("delay"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			// Allow plain numbers as milliseconds
+			millis, numberErr := strconv.Atoi(value)
+			if numberErr != nil {
+				writeMockError(w, http.StatusBadRequest, "delay must be a duration such as 500ms or 2s")
+				return
+			}
+			parsed = time.Duration(millis) * time.Millisecond
+		}
+		if parsed < 0 || parsed > maxUtilityDelay {
+			writeMockError(w, http.StatusBadRequest, fmt.Sprintf("delay must be between 0 and %s", maxUtilityDelay))
+			return
+		}
+		delay = parsed
+	}
+
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		return
+	}
+
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		w.WriteHeader(status)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":  status,
+		"message": http.StatusText(status),
+		"delay":   delay.String(),
+		"method":  r.Method,
+	}
+	if status >= 400 {
+		response["error"] = http.StatusText(status)
+	}
+	writeMockJSON(w, status, response)
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,77 @@
+package logger
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// commonLogTime is the timestamp format used by the Common and Combined log formats.
+const commonLogTime = "02/Jan/2006:15:04:05 -0700"
+
+// AccessEntry holds the details of a served request written to an access log.
+type AccessEntry struct {
+	ClientIP   string
+	Time       time.Time
+	Method     string
+	RequestURI string
+	Proto      string
+	Status     int
+	Size       int64
+	Referer    string
+	UserAgent  string
+}
+
+// AccessLog writes served requests to a file in the Common or Combined log format.
+type AccessLog struct {
+	file   *RotatingFile
+	format string
+}
+
+// OpenAccessLog opens the access log file at the given path using the
+// "common" or "combined" format, rotating it at the default size.
+func OpenAccessLog(path string, format string) (*AccessLog, error) {
+	if format != "common" && format != "combined" {
+		return nil, fmt.Errorf("unknown access log format %q, expected common or combined", format)
+	}
+
+	file, err := OpenRotatingFile(path, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &AccessLog{file: file, format: format}, nil
+}
+
+// Log writes the given request to the access log.
+func (al *AccessLog) Log(entry AccessEntry) error {
+	size := "-"
+	if entry.Size > 0 {
+		size = strconv.FormatInt(entry.Size, 10)
+	}
+
+	line := fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s",
+		entry.ClientIP, entry.Time.Format(commonLogTime), entry.Method, entry.RequestURI, entry.Proto, entry.Status, size)
+	if al.format == "combined" {
+		line += fmt.Sprintf(" %q %q", orDash(entry.Referer), orDash(entry.UserAgent))
+	}
+
+	_, err := al.file.Write([]byte(line + "\n"))
+	return err
+}
+
+// Path provides the path of the access log file.
+func (al *AccessLog) Path() string {
+	return al.file.Path()
+}
+
+// Close closes the access log file.
+func (al *AccessLog) Close() error {
+	return al.file.Close()
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -1,29 +1,303 @@
 package logger
 
-import "github.com/fatih/color"
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
-var isVerbose bool
+	"github.com/fatih/color"
+)
+
+// Level is the severity of a log message.
+type Level int
+
+// The log levels, from most to least verbose. Errors are always shown.
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = map[Level]string{
+	DebugLevel: "debug",
+	InfoLevel:  "info",
+	WarnLevel:  "warn",
+	ErrorLevel: "error",
+}
+
+func (level Level) String() string {
+	return levelNames[level]
+}
+
+// ParseLevel finds the level with the given name.
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", name)
+}
+
+// Fields are structured values, such as a server id or path, attached to a log message.
+type Fields map[string]interface{}
+
+// Options sets how and where log messages are written.
+type Options struct {
+	Level      Level
+	Format     string
+	File       string
+	MaxSize    int64
+	MaxBackups int
+}
+
+var output = struct {
+	sync.Mutex
+	level  Level
+	format string
+	file   *RotatingFile
+}{level: InfoLevel, format: "text"}
+
+// Message is a logged message as passed to listeners.
+type Message struct {
+	Time   time.Time
+	Level  Level
+	Text   string
+	Err    error
+	Fields Fields
+}
+
+type listener struct {
+	handle func(Message)
+}
+
+// listeners are replaced, rather than changed, when added or removed so messages can be
+// passed to the current listeners without holding the lock.
+var listeners struct {
+	sync.Mutex
+	handlers []*listener
+}
+
+// AddListener registers a function to be called with each message that is logged,
+// providing a function to remove it again.
+func AddListener(handle func(Message)) (remove func()) {
+	added := &listener{handle: handle}
+	listeners.Lock()
+	listeners.handlers = append(append([]*listener(nil), listeners.handlers...), added)
+	listeners.Unlock()
+
+	return func() {
+		listeners.Lock()
+		defer listeners.Unlock()
+
+		handlers := make([]*listener, 0, len(listeners.handlers))
+		for _, handler := range listeners.handlers {
+			if handler != added {
+				handlers = append(handlers, handler)
+			}
+		}
+		listeners.handlers = handlers
+	}
+}
+
+// Configure sets the level and format of logged messages, and opens the given log file, if any,
+// which is rotated once it reaches the given size in megabytes.
+func Configure(options Options) error {
+	if options.Format != "text" && options.Format != "json" {
+		return fmt.Errorf("unknown log format %q, expected text or json", options.Format)
+	}
+
+	var file *RotatingFile
+	if options.File != "" {
+		var err error
+		file, err = OpenRotatingFile(options.File, options.MaxSize, options.MaxBackups)
+		if err != nil {
+			return err
+		}
+	}
+
+	output.Lock()
+	defer output.Unlock()
+	if output.file != nil {
+		output.file.Close()
+	}
+	output.level = options.Level
+	output.format = options.Format
+	output.file = file
+	return nil
+}
 
 // ShowVerboseOutput will activate the verbose status of this logger.
 func ShowVerboseOutput() {
-	isVerbose = true
+	output.Lock()
+	output.level = DebugLevel
+	output.Unlock()
+}
+
+// Close closes the log file, if open.
+func Close() {
+	output.Lock()
+	defer output.Unlock()
+	if output.file != nil {
+		output.file.Close()
+		output.file = nil
+	}
+}
+
+// Entry is a set of fields that are logged along with each message.
+type Entry struct {
+	fields Fields
+}
+
+// With provides an entry that logs messages with the given fields.
+func With(fields Fields) *Entry {
+	return &Entry{fields: fields}
+}
+
+// With provides an entry that logs messages with the given fields in addition to those of this entry.
+func (entry *Entry) With(fields Fields) *Entry {
+	merged := make(Fields, len(entry.fields)+len(fields))
+	for key, value := range entry.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return &Entry{fields: merged}
+}
+
+// Debug logs text that is only shown to those with verbose output active.
+func (entry *Entry) Debug(text string) {
+	entry.log(DebugLevel, text, nil)
+}
+
+// Info logs text about the normal running of webby.
+func (entry *Entry) Info(text string) {
+	entry.log(InfoLevel, text, nil)
+}
+
+// Warn logs text about something that may need attention.
+func (entry *Entry) Warn(text string) {
+	entry.log(WarnLevel, text, nil)
+}
+
+// Error logs the given error, which is always shown, for the given event.
+func (entry *Entry) Error(event string, err error) {
+	entry.log(ErrorLevel, event, err)
+}
+
+func (entry *Entry) log(level Level, text string, err error) {
+	output.Lock()
+	if level < output.level {
+		output.Unlock()
+		return
+	}
+
+	now := time.Now()
+	line := formatLine(output.format, now, level, text, err, entry.fields)
+	if output.format == "json" {
+		os.Stdout.Write(line)
+	} else {
+		printColoured(level, text, err, entry.fields)
+	}
+
+	if output.file != nil {
+		output.file.Write(line)
+	}
+	output.Unlock()
+
+	// Listeners are called outside of the output lock so they can log themselves
+	listeners.Lock()
+	handlers := listeners.handlers
+	listeners.Unlock()
+	for _, handler := range handlers {
+		handler.handle(Message{Time: now, Level: level, Text: text, Err: err, Fields: entry.fields})
+	}
+}
+
+// printColoured shows a message on the console in a format suited to its level.
+func printColoured(level Level, text string, err error, fields Fields) {
+	suffix := formatFields(fields)
+	switch level {
+	case DebugLevel:
+		color.Blue("[DEVLOG] %s%s", text, suffix)
+	case InfoLevel:
+		color.Green("%s%s", text, suffix)
+	case WarnLevel:
+		color.Yellow("[WARN] %s%s", text, suffix)
+	case ErrorLevel:
+		if err == nil {
+			color.Red("[ERROR] %s%s", text, suffix)
+			return
+		}
+		color.Red("[ERROR] on %s; %s%s", text, err.Error(), suffix)
+	}
+}
+
+// formatLine formats a message as a single plain text, or JSON, line.
+func formatLine(format string, at time.Time, level Level, text string, err error, fields Fields) []byte {
+	if format == "json" {
+		record := make(map[string]interface{}, len(fields)+4)
+		for key, value := range fields {
+			record[key] = value
+		}
+		record["time"] = at.Format(time.RFC3339)
+		record["level"] = level.String()
+		record["msg"] = text
+		if err != nil {
+			record["error"] = err.Error()
+		}
+		line, _ := json.Marshal(record)
+		return append(line, '\n')
+	}
+
+	message := text
+	if err != nil {
+		message = "on " + text + "; " + err.Error()
+	}
+	return []byte(fmt.Sprintf("%s %-5s %s%s\n", at.Format(time.RFC3339), strings.ToUpper(level.String()), message, formatFields(fields)))
+}
+
+// formatFields formats fields as sorted key=value pairs, quoting values containing spaces.
+func formatFields(fields Fields) string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	for _, key := range keys {
+		value := fmt.Sprint(fields[key])
+		if strings.ContainsAny(value, " \t\"") {
+			value = fmt.Sprintf("%q", value)
+		}
+		builder.WriteString(" " + key + "=" + value)
+	}
+	return builder.String()
 }
 
 // Error will print out the given error in a suitable attention-seeking format.
 func Error(event string, err error) {
-	if isVerbose {
-		color.Red("[ERROR] on %s; %s", event, err.Error())
-	}
+	With(nil).Error(event, err)
+}
+
+// Warn will show the given text as something that may need attention.
+func Warn(text string) {
+	With(nil).Warn(text)
 }
 
 // Devlog will show the given text to those with verbose output active.
 func Devlog(text string) {
-	if isVerbose {
-		color.Blue("[DEVLOG] %s", text)
-	}
+	With(nil).Debug(text)
 }
 
 // Display will show the given text in a user friendly display format.
 func Display(text string) {
-	color.Green("%s", text)
+	With(nil).Info(text)
 }
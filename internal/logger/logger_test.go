@@ -0,0 +1,103 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLevel(t *testing.T) {
+	for name, expected := range map[string]Level{"debug": DebugLevel, "INFO": InfoLevel, "Warn": WarnLevel, "error": ErrorLevel} {
+		if level, err := ParseLevel(name); err != nil || level != expected {
+			t.Errorf("Expected %q to parse as %s, got %s %v", name, expected, level, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected an unknown level to be rejected")
+	}
+}
+
+func TestFormatLine(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	fields := Fields{"server": 2, "path": "/my page"}
+
+	line := string(formatLine("text", at, ErrorLevel, "Serving", errors.New("not found"), fields))
+	expected := "2024-03-01T12:30:00Z ERROR on Serving; not found path=\"/my page\" server=2\n"
+	if line != expected {
+		t.Errorf("Expected text line %q, got %q", expected, line)
+	}
+
+	var record map[string]interface{}
+	err := json.Unmarshal(formatLine("json", at, InfoLevel, "Started", nil, fields), &record)
+	if err != nil || record["time"] != "2024-03-01T12:30:00Z" || record["level"] != "info" || record["msg"] != "Started" ||
+		record["server"] != float64(2) || record["path"] != "/my page" || record["error"] != nil {
+		t.Errorf("Unexpected JSON line %v %v", record, err)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+	logPath := filepath.Join(tempDir, "logs", "webby.log")
+
+	rf, err := OpenRotatingFile(logPath, 1, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer rf.Close()
+
+	line := []byte(strings.Repeat("a", 600*1024) + "\n")
+	for i := 0; i < 4; i++ {
+		if _, err := rf.Write(line); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	for _, name := range []string{"webby.log", "webby.log.1", "webby.log.2"} {
+		if stat, err := os.Stat(filepath.Join(tempDir, "logs", name)); err != nil || stat.Size() != int64(len(line)) {
+			t.Errorf("Expected %s to hold a single line", name)
+		}
+	}
+	if _, err := os.Stat(logPath + ".3"); err == nil {
+		t.Error("Expected only two rotated files to be kept")
+	}
+
+	// Block rotation with folders, which cannot be removed or replaced, in place of the rotated files
+	for _, suffix := range []string{".1", ".2"} {
+		os.Remove(logPath + suffix)
+		os.MkdirAll(filepath.Join(logPath+suffix, "blocked"), 0755)
+	}
+	if _, err := rf.Write(line); err == nil {
+		t.Error("Expected the failed rotation to be reported")
+	}
+	rf.Write([]byte("still logging\n"))
+	if rf.file == nil {
+		t.Fatal("Expected the current file to be reopened after a failed rotation")
+	}
+	data, _ := ioutil.ReadFile(logPath)
+	if !strings.HasSuffix(string(data), "still logging\n") {
+		t.Error("Expected the current file to be written to after a failed rotation")
+	}
+}
+
+func TestListeners(t *testing.T) {
+	var received []Message
+	remove := AddListener(func(message Message) {
+		received = append(received, message)
+	})
+
+	With(Fields{"server": 1}).Warn("First warning")
+	if len(received) != 1 || received[0].Level != WarnLevel || received[0].Text != "First warning" || received[0].Fields["server"] != 1 {
+		t.Fatalf("Expected the listener to receive the warning, got %+v", received)
+	}
+
+	remove()
+	Warn("Second warning")
+	if len(received) != 1 {
+		t.Errorf("Expected a removed listener to receive no more messages, got %+v", received)
+	}
+}
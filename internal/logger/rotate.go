@@ -0,0 +1,122 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// defaultMaxSize is the size, in megabytes, log files are rotated at if no size is given.
+const defaultMaxSize = 10
+
+// defaultMaxBackups is how many rotated log files are kept if no count is given.
+const defaultMaxBackups = 3
+
+// RotatingFile is a log file that is moved aside, keeping a number of
+// previous files as name.1, name.2 and so on, once it reaches a size.
+type RotatingFile struct {
+	mutex      sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// OpenRotatingFile opens, or creates, the log file at the given path to append to.
+// The file is rotated once it reaches the given size in megabytes.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	if maxBackups <= 0 {
+		maxBackups = defaultMaxBackups
+	}
+
+	rf := &RotatingFile{path: path, maxSize: maxSize * 1024 * 1024, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(rf.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file = file
+	rf.size = stat.Size()
+	return nil
+}
+
+// Write appends the given data to the file, rotating it first if it would become too large.
+func (rf *RotatingFile) Write(data []byte) (int, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	// Keep writing to the current file if it could not be rotated, reporting the failure
+	var rotateErr error
+	if rf.size > 0 && rf.size+int64(len(data)) > rf.maxSize {
+		rotateErr = rf.rotate()
+		if rf.file == nil {
+			return 0, rotateErr
+		}
+	}
+
+	n, err := rf.file.Write(data)
+	rf.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// rotate moves the current file aside, shifting previous files along and removing the oldest.
+// The current file is reopened if it cannot be moved.
+func (rf *RotatingFile) rotate() error {
+	rf.file.Close()
+	rf.file = nil
+
+	os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.maxBackups))
+	for i := rf.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+		if openErr := rf.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	return rf.open()
+}
+
+// Path provides the path of the current log file.
+func (rf *RotatingFile) Path() string {
+	return rf.path
+}
+
+// Close closes the file.
+func (rf *RotatingFile) Close() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
@@ -0,0 +1,159 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ssddanbrown/webby/internal/logger"
+)
+
+// maxActivity is how many recent events with a message are kept for the dashboard's activity log.
+const maxActivity = 100
+
+// dashboardStatsInterval is how often server statistics are sent to connected dashboards.
+var dashboardStatsInterval = 2 * time.Second
+
+// dashboardEvent is sent to dashboards over the manager's event stream.
+// Events with a message are also shown in the dashboard's activity log.
+type dashboardEvent struct {
+	Type    string                `json:"type"`
+	Time    time.Time             `json:"time"`
+	Level   string                `json:"level,omitempty"`
+	Message string                `json:"message,omitempty"`
+	Server  int                   `json:"server,omitempty"`
+	Stats   []serverStatsResponse `json:"stats,omitempty"`
+	History []dashboardEvent      `json:"history,omitempty"`
+}
+
+// eventHub passes events to each dashboard connected to the manager's event stream.
+type eventHub struct {
+	sync.Mutex
+	clients  map[chan dashboardEvent]bool
+	activity []dashboardEvent
+}
+
+func (hub *eventHub) subscribe() (chan dashboardEvent, []dashboardEvent) {
+	hub.Lock()
+	defer hub.Unlock()
+
+	if hub.clients == nil {
+		hub.clients = make(map[chan dashboardEvent]bool)
+	}
+	events := make(chan dashboardEvent, 32)
+	hub.clients[events] = true
+	return events, append([]dashboardEvent(nil), hub.activity...)
+}
+
+func (hub *eventHub) unsubscribe(events chan dashboardEvent) {
+	hub.Lock()
+	delete(hub.clients, events)
+	hub.Unlock()
+}
+
+// publish sends the given event to all connected dashboards, dropping it for
+// any too far behind to keep up.
+func (m *Server) publish(event dashboardEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	m.events.Lock()
+	defer m.events.Unlock()
+
+	if event.Message != "" {
+		m.events.activity = append(m.events.activity, event)
+		if len(m.events.activity) > maxActivity {
+			m.events.activity = m.events.activity[len(m.events.activity)-maxActivity:]
+		}
+	}
+	for events := range m.events.clients {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// publishLogMessage passes warnings and errors on to dashboards for their activity log.
+func (m *Server) publishLogMessage(message logger.Message) {
+	if message.Level < logger.WarnLevel {
+		return
+	}
+
+	text := message.Text
+	if message.Err != nil {
+		text += ": " + message.Err.Error()
+	}
+	server, _ := message.Fields["server"].(int)
+	m.publish(dashboardEvent{Type: "log", Time: message.Time, Level: message.Level.String(), Message: text, Server: server})
+}
+
+// serversChanged tells dashboards to show the current servers, noting the given message in their activity log.
+func (m *Server) serversChanged(message string) {
+	m.publish(dashboardEvent{Type: "servers", Message: message})
+}
+
+// statsEvent provides the current statistics of all servers to send to dashboards.
+func (m *Server) statsEvent() dashboardEvent {
+	servers := m.Servers()
+	stats := make([]serverStatsResponse, 0, len(servers))
+	for _, server := range servers {
+		stats = append(stats, serverStatsResponse{ID: server.ID, URL: server.Url(), Root: server.RootPath, Stats: server.Stats()})
+	}
+	return dashboardEvent{Type: "stats", Time: time.Now(), Stats: stats}
+}
+
+func (m *Server) addEventRoutes(handler *http.ServeMux) {
+
+	// Stream changes to servers, their statistics and activity to the dashboard
+	handler.HandleFunc("/events", func(w http.ResponseWriter, req *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		events, history := m.events.subscribe()
+		defer m.events.unsubscribe(events)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+
+		send := func(event dashboardEvent) bool {
+			data, err := json.Marshal(event)
+			if err != nil {
+				logger.Error("Encoding dashboard event", err)
+				return true
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return false
+			}
+			flusher.Flush()
+			return true
+		}
+
+		// Start with the recent activity, replacing any shown before reconnecting
+		if !send(dashboardEvent{Type: "history", Time: time.Now(), History: history}) || !send(m.statsEvent()) {
+			return
+		}
+
+		ticker := time.NewTicker(dashboardStatsInterval)
+		defer ticker.Stop()
+		for {
+			var event dashboardEvent
+			select {
+			case event = <-events:
+			case <-ticker.C:
+				event = m.statsEvent()
+			case <-req.Context().Done():
+				return
+			}
+			if !send(event) {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package manager
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/GeertJohan/go.rice"
+	"github.com/ssddanbrown/webby/internal/fileserver"
+	"github.com/ssddanbrown/webby/internal/logger"
+)
+
+type fixturesPage struct {
+	Server      *fileserver.FileServer
+	Fixtures    []*fileserver.Fixture
+	EditFile    string
+	EditContent string
+	Error       string
+}
+
+// addFixtureRoutes adds the routes used to manage recorded proxy fixtures
+func (m *Server) addFixtureRoutes(handler *http.ServeMux, fileBox *rice.Box) {
+
+	// Change how a file server records and replays proxied responses
+	handler.HandleFunc("/set-fixture-mode", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Set fixture mode handler", err)
+			return
+		}
+
+		err = server.SetFixtureMode(req.FormValue("mode"))
+		if err != nil {
+			logger.Error("Set fixture mode handler", err)
+			return
+		}
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// List and edit the recorded fixtures of a file server
+	handler.HandleFunc("/fixtures", func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Fixtures handler", err)
+			http.NotFound(w, req)
+			return
+		}
+
+		page := fixturesPage{Server: server}
+		page.Fixtures, err = server.ListFixtures()
+		if err != nil {
+			page.Error = err.Error()
+		}
+
+		if editFile := req.FormValue("file"); editFile != "" {
+			content, err := server.ReadFixtureFile(editFile)
+			if err != nil {
+				page.Error = err.Error()
+			} else {
+				page.EditFile = editFile
+				page.EditContent = string(content)
+			}
+		}
+
+		if req.Method == "POST" {
+			page.EditContent = req.FormValue("content")
+			err = server.SaveFixtureFile(page.EditFile, []byte(page.EditContent))
+			if err == nil {
+				http.Redirect(w, req, fmt.Sprintf("/fixtures?id=%d", server.ID), http.StatusSeeOther)
+				return
+			}
+			page.Error = err.Error()
+		}
+
+		templString := fileBox.MustString("fixtures.html")
+		templ, _ := template.New("Fixtures").Parse(templString)
+		templ.Execute(w, page)
+	})
+
+	// Delete a recorded fixture
+	handler.HandleFunc("/delete-fixture", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Delete fixture handler", err)
+			return
+		}
+
+		err = server.DeleteFixture(req.FormValue("file"))
+		if err != nil {
+			logger.Error("Delete fixture handler", err)
+		}
+
+		http.Redirect(w, req, fmt.Sprintf("/fixtures?id=%d", server.ID), http.StatusSeeOther)
+	}))
+}
@@ -0,0 +1,117 @@
+package manager
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/GeertJohan/go.rice"
+	"github.com/ssddanbrown/webby/internal/fileserver"
+	"github.com/ssddanbrown/webby/internal/logger"
+)
+
+type formsPage struct {
+	Server      *fileserver.FileServer
+	Submissions []*fileserver.FormSubmission
+	Error       string
+}
+
+// addFormRoutes adds the routes used to configure and view captured form submissions
+func (m *Server) addFormRoutes(handler *http.ServeMux, fileBox *rice.Box) {
+
+	// Change which form posts a file server captures
+	handler.HandleFunc("/set-forms", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Set forms handler", err)
+			return
+		}
+
+		var config *fileserver.FormConfig
+		paths := splitList(req.FormValue("paths"))
+		captureAll := req.FormValue("capture_all") != ""
+		if len(paths) > 0 || captureAll {
+			config = &fileserver.FormConfig{
+				Paths:      paths,
+				CaptureAll: captureAll,
+				Redirect:   req.FormValue("redirect"),
+			}
+		}
+
+		err = server.SetForms(config)
+		if err != nil {
+			logger.Error("Set forms handler", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// List the captured form submissions of a file server
+	handler.HandleFunc("/forms", func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Forms handler", err)
+			http.NotFound(w, req)
+			return
+		}
+
+		page := formsPage{Server: server}
+		page.Submissions, err = server.ListFormSubmissions()
+		if err != nil {
+			page.Error = err.Error()
+		}
+
+		templString := fileBox.MustString("forms.html")
+		templ, _ := template.New("Forms").Parse(templString)
+		templ.Execute(w, page)
+	})
+
+	// Download a file uploaded with a form submission
+	handler.HandleFunc("/form-file", func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Form file handler", err)
+			http.NotFound(w, req)
+			return
+		}
+
+		fPath, err := server.FormFilePath(req.FormValue("submission"), req.FormValue("file"))
+		if err != nil {
+			logger.Error("Form file handler", err)
+			http.NotFound(w, req)
+			return
+		}
+
+		http.ServeFile(w, req, fPath)
+	})
+
+	// Delete a captured form submission
+	handler.HandleFunc("/delete-form-submission", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Delete form submission handler", err)
+			return
+		}
+
+		err = server.DeleteFormSubmission(req.FormValue("submission"))
+		if err != nil {
+			logger.Error("Delete form submission handler", err)
+		}
+
+		http.Redirect(w, req, fmt.Sprintf("/forms?id=%d", server.ID), http.StatusSeeOther)
+	}))
+}
+
+// splitList splits a comma separated list, as entered in the manager, ignoring empty items
+func splitList(text string) []string {
+	var items []string
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,59 @@
+package manager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ssddanbrown/webby/internal/logger"
+)
+
+// idleCheckInterval is how often servers are checked for having been idle for too long.
+var idleCheckInterval = 30 * time.Second
+
+// watchIdle periodically closes idle file servers, stopping the manager
+// once it has been without servers for its idle timeout.
+func (m *Server) watchIdle() {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if m.checkIdle(now) {
+			logger.Display("Stopping Webby Manager after having no servers for a while")
+			m.stop()
+			return
+		}
+	}
+}
+
+// checkIdle closes the file servers idle at the given time. It returns true
+// if the manager has been without servers for longer than its idle timeout.
+func (m *Server) checkIdle(now time.Time) bool {
+	for _, server := range m.Servers() {
+		if server.IsIdle(now) {
+			logger.Display(fmt.Sprintf("Closing idle server for %s at %s", server.RootPath, server.Url()))
+			m.removeFileServer(server)
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.FileServers) > 0 {
+		m.emptySince = time.Time{}
+		return false
+	}
+	if m.emptySince.IsZero() {
+		m.emptySince = now
+	}
+	timeout := time.Duration(m.Options.ManagerIdleTimeout) * time.Minute
+	return timeout > 0 && now.Sub(m.emptySince) >= timeout
+}
+
+// stop ends the manager's user interface, returning from Listen.
+func (m *Server) stop() {
+	m.shutdownOnce.Do(func() {
+		if m.removeListener != nil {
+			m.removeListener()
+		}
+		close(m.shutdown)
+	})
+}
@@ -0,0 +1,202 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/GeertJohan/go.rice"
+	"github.com/ssddanbrown/webby/internal/fileserver"
+	"github.com/ssddanbrown/webby/internal/logger"
+)
+
+type inspectorPage struct {
+	Server *fileserver.FileServer
+}
+
+// har is the root of a HTTP Archive (HAR 1.2) file.
+type har struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// addInspectorRoutes adds the routes used to view and export the requests made to file servers
+func (m *Server) addInspectorRoutes(handler *http.ServeMux, fileBox *rice.Box) {
+
+	// Show the live updating request inspector of a file server
+	handler.HandleFunc("/inspector", func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Inspector handler", err)
+			http.NotFound(w, req)
+			return
+		}
+
+		templString := fileBox.MustString("inspector.html")
+		templ, _ := template.New("Inspector").Parse(templString)
+		templ.Execute(w, inspectorPage{Server: server})
+	})
+
+	// List the recorded requests of a file server made after the given request ID
+	handler.HandleFunc("/inspector/requests", func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Inspector requests handler", err)
+			http.NotFound(w, req)
+			return
+		}
+
+		since, _ := strconv.ParseInt(req.FormValue("since"), 10, 64)
+		records := server.RequestLog(since)
+		if records == nil {
+			records = []*fileserver.RequestRecord{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+	})
+
+	// Download the recorded requests of a file server as a HAR file
+	handler.HandleFunc("/inspector/har", func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Inspector HAR handler", err)
+			http.NotFound(w, req)
+			return
+		}
+
+		fileName := fmt.Sprintf("webby-%d-%s.har", server.Port, time.Now().Format("20060102-150405"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+fileName+"\"")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(newHAR(server.RequestLog(0)))
+	})
+
+	// Clear the recorded requests of a file server
+	handler.HandleFunc("/inspector/clear", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Inspector clear handler", err)
+			return
+		}
+
+		server.ClearRequestLog()
+		http.Redirect(w, req, fmt.Sprintf("/inspector?id=%d", server.ID), http.StatusSeeOther)
+	}))
+}
+
+// newHAR builds a HAR file from the given request records.
+func newHAR(records []*fileserver.RequestRecord) *har {
+	entries := make([]harEntry, 0, len(records))
+	for _, record := range records {
+		queryString := []harNameValue{}
+		if parsed, err := url.Parse(record.URL); err == nil {
+			queryString = toNameValues(parsed.Query())
+		}
+
+		entries = append(entries, harEntry{
+			StartedDateTime: record.Started.Format(time.RFC3339Nano),
+			Time:            record.Duration,
+			Request: harRequest{
+				Method:      record.Method,
+				URL:         record.URL,
+				HTTPVersion: record.Proto,
+				Cookies:     []harNameValue{},
+				Headers:     toNameValues(record.RequestHeaders),
+				QueryString: queryString,
+				HeadersSize: -1,
+				BodySize:    record.RequestSize,
+			},
+			Response: harResponse{
+				Status:      record.Status,
+				StatusText:  http.StatusText(record.Status),
+				HTTPVersion: record.Proto,
+				Cookies:     []harNameValue{},
+				Headers:     toNameValues(record.ResponseHeaders),
+				Content:     harContent{Size: record.Size, MimeType: record.ResponseHeaders.Get("Content-Type")},
+				RedirectURL: record.ResponseHeaders.Get("Location"),
+				HeadersSize: -1,
+				BodySize:    record.Size,
+			},
+			Timings: harTimings{Send: 0, Wait: record.Duration, Receive: 0},
+		})
+	}
+
+	return &har{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "Webby", Version: "dev"},
+		Entries: entries,
+	}}
+}
+
+func toNameValues(values map[string][]string) []harNameValue {
+	pairs := []harNameValue{}
+	for name, items := range values {
+		for _, value := range items {
+			pairs = append(pairs, harNameValue{Name: name, Value: value})
+		}
+	}
+	return pairs
+}
@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ssddanbrown/webby/internal/fileserver"
+)
+
+// serverStatsResponse is the API representation of a file server's usage statistics.
+type serverStatsResponse struct {
+	ID    int                    `json:"id"`
+	URL   string                 `json:"url"`
+	Root  string                 `json:"root"`
+	Stats fileserver.ServerStats `json:"stats"`
+}
+
+// metricsLabelEscaper escapes label values for the Prometheus text format.
+var metricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (m *Server) addMetricsRoutes(handler *http.ServeMux) {
+
+	// Get the usage statistics of all file servers, or of the one given by id, as JSON
+	handler.HandleFunc("/stats", func(w http.ResponseWriter, req *http.Request) {
+		servers := m.Servers()
+		if req.FormValue("id") != "" {
+			server, err := m.findFileServerForRequest(req)
+			if err != nil {
+				http.NotFound(w, req)
+				return
+			}
+			servers = []*fileserver.FileServer{server}
+		}
+
+		response := make([]serverStatsResponse, 0, len(servers))
+		for _, server := range servers {
+			response = append(response, serverStatsResponse{
+				ID:    server.ID,
+				URL:   server.Url(),
+				Root:  server.RootPath,
+				Stats: server.Stats(),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+
+	// Get the usage statistics of all file servers in the Prometheus text format
+	handler.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w, m.Servers())
+	})
+}
+
+// writeMetrics writes the usage statistics of the given file servers in the Prometheus text format.
+func writeMetrics(w io.Writer, servers []*fileserver.FileServer) {
+	stats := make([]fileserver.ServerStats, len(servers))
+	labels := make([]string, len(servers))
+	for i, server := range servers {
+		stats[i] = server.Stats()
+		labels[i] = fmt.Sprintf(`server="%d",port="%d",root="%s"`,
+			server.ID, server.Port, metricsLabelEscaper.Replace(server.RootPath))
+	}
+
+	writeMetricHeader(w, "webby_servers", "gauge", "Number of running servers.")
+	fmt.Fprintf(w, "webby_servers %d\n", len(servers))
+
+	writeMetricHeader(w, "webby_http_requests_total", "counter", "Requests handled by each server.")
+	for i := range servers {
+		fmt.Fprintf(w, "webby_http_requests_total{%s} %d\n", labels[i], stats[i].Requests)
+	}
+
+	writeMetricHeader(w, "webby_http_responses_total", "counter", "Responses sent by each server, by status code.")
+	for i := range servers {
+		codes := make([]int, 0, len(stats[i].StatusCodes))
+		for code := range stats[i].StatusCodes {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(w, "webby_http_responses_total{%s,code=\"%d\"} %d\n", labels[i], code, stats[i].StatusCodes[code])
+		}
+	}
+
+	writeMetricHeader(w, "webby_http_response_bytes_total", "counter", "Response body bytes served by each server.")
+	for i := range servers {
+		fmt.Fprintf(w, "webby_http_response_bytes_total{%s} %d\n", labels[i], stats[i].BytesServed)
+	}
+
+	writeMetricHeader(w, "webby_last_access_timestamp_seconds", "gauge", "Unix time of the last request to each server, or 0 if never requested.")
+	for i := range servers {
+		lastAccess := 0.0
+		if !stats[i].LastAccess.IsZero() {
+			lastAccess = float64(stats[i].LastAccess.UnixMilli()) / 1000
+		}
+		fmt.Fprintf(w, "webby_last_access_timestamp_seconds{%s} %s\n", labels[i], strconv.FormatFloat(lastAccess, 'f', -1, 64))
+	}
+
+	writeMetricHeader(w, "webby_livereload_clients", "gauge", "Live reload clients connected from pages of each server.")
+	for i := range servers {
+		fmt.Fprintf(w, "webby_livereload_clients{%s} %d\n", labels[i], stats[i].LiveReloadClients)
+	}
+}
+
+func writeMetricHeader(w io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
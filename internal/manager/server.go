@@ -2,17 +2,19 @@ package manager
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
+	"github.com/ssddanbrown/webby/internal/certs"
 	"github.com/ssddanbrown/webby/internal/fileserver"
 	"github.com/ssddanbrown/webby/internal/logger"
 	"github.com/ssddanbrown/webby/internal/util"
 	"html/template"
 	"net"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/GeertJohan/go.rice"
@@ -21,40 +23,203 @@ import (
 )
 
 type Server struct {
-	FileServers    []fileserver.FileServer
+	FileServers    []*fileserver.FileServer
 	WatchedFolders []string
+	mutex          sync.RWMutex
+	watchers       map[string]map[int]bool
 	fileWatcher    *fsnotify.Watcher
 	changedFiles   chan string
 	sockets        []*websocket.Conn
+	socketMutex    sync.Mutex
 	lastFileChange int64
 	NetworkIP      string
 	Options        *util.Options
+	authority      *certs.Authority
+	emptySince     time.Time
+	shutdown       chan struct{}
+	events         eventHub
+	shutdownOnce   sync.Once
+	removeListener func()
 }
 
 // NewServer creates a new server instance using the given Options
 func NewServer(options *util.Options) *Server {
 	server := new(Server)
 	server.Options = options
+	server.shutdown = make(chan struct{})
+	server.removeListener = logger.AddListener(server.publishLogMessage)
+
+	if options.HTTPSEnabled {
+		err := server.setupTLS()
+		if err != nil {
+			logger.Error("HTTPS certificate setup", err)
+			options.HTTPSEnabled = false
+		}
+	}
+
 	return server
 }
 
+// Scheme provides the URL scheme used for links to webby's servers
+func (m *Server) Scheme() string {
+	if m.Options.TLSConfig != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// setupTLS loads the local development certificate authority and issues a
+// certificate, for this machine, to be used by all servers.
+func (m *Server) setupTLS() error {
+	dir, err := certs.DefaultDir()
+	if err != nil {
+		return err
+	}
+
+	authority, err := certs.LoadOrCreateAuthority(dir)
+	if err != nil {
+		return err
+	}
+
+	cert, err := authority.IssueCertificate(certs.LocalHosts())
+	if err != nil {
+		return err
+	}
+
+	m.authority = authority
+	m.Options.TLSConfig = certs.NewTLSConfig(cert)
+	logger.Devlog("Loaded development certificate authority from " + dir)
+	return nil
+}
+
 // AddFileServer adds a file, for the given path, to the manager
 func (m *Server) AddFileServer(path string) (*fileserver.FileServer, error) {
-	fServer, err := m.findFileServerByPath(util.FormatRootPath(path))
-	if err == nil {
-		logger.Display("Server already running")
-		return fServer, err
+	return m.AddFileServerOnPort(path, 0)
+}
+
+// AddFileServerOnPort adds a file server, for the given path, to the manager
+// on the given port, or a free port if zero.
+func (m *Server) AddFileServerOnPort(path string, port int) (*fileserver.FileServer, error) {
+	fServer, _, err := m.addFileServer(path, port)
+	return fServer, err
+}
+
+// addFileServer adds a file server as AddFileServerOnPort, also providing if
+// an already running server for the path was provided instead.
+func (m *Server) addFileServer(path string, port int) (*fileserver.FileServer, bool, error) {
+	matches := func(fServer *fileserver.FileServer) bool {
+		return fServer.ProxyURL == ""
+	}
+	start := func() (*fileserver.FileServer, error) {
+		return fileserver.StartFileServerOnPort(path, port, m.Options)
 	}
 
-	fServer, err = fileserver.StartFileServer(path, m.Options)
+	fServer, existing, err := m.addServer(path, matches, start)
+	if err != nil || existing {
+		return fServer, existing, err
+	}
+	logger.Display(fmt.Sprintf("Serving files from %s at %s", fServer.RootPath, fServer.Url()))
+	m.serversChanged(fmt.Sprintf("Started server for %s at %s", fServer.RootPath, fServer.Url()))
+	return fServer, false, nil
+}
+
+// AddProxyServer adds a server, proxying to the given upstream URL, to the
+// manager while watching the given path for changes. A proxy server already
+// running for the path is provided instead, whatever its upstream.
+func (m *Server) AddProxyServer(path string, upstream string) (*fileserver.FileServer, error) {
+	return m.AddProxyServerOnPort(path, upstream, 0)
+}
+
+// AddProxyServerOnPort adds a proxy server to the manager, as AddProxyServer,
+// on the given port, or a free port if zero.
+func (m *Server) AddProxyServerOnPort(path string, upstream string, port int) (*fileserver.FileServer, error) {
+	fServer, _, err := m.addProxyServer(path, upstream, port)
+	return fServer, err
+}
+
+// addProxyServer adds a proxy server as AddProxyServerOnPort, also providing if
+// an already running proxy server for the path was provided instead.
+func (m *Server) addProxyServer(path string, upstream string, port int) (*fileserver.FileServer, bool, error) {
+	matches := func(fServer *fileserver.FileServer) bool {
+		return fServer.ProxyURL != ""
+	}
+	start := func() (*fileserver.FileServer, error) {
+		return fileserver.StartProxyServerOnPort(path, upstream, port, m.Options)
+	}
+
+	fServer, existing, err := m.addServer(path, matches, start)
+	if err != nil || existing {
+		return fServer, existing, err
+	}
+	logger.Display(fmt.Sprintf("Proxying %s at %s, watching %s", fServer.ProxyURL, fServer.Url(), fServer.RootPath))
+	m.serversChanged(fmt.Sprintf("Started server proxying %s at %s", fServer.ProxyURL, fServer.Url()))
+	return fServer, false, nil
+}
+
+// addServer starts a server for the given path, using the given start function, and watches its
+// folder. If a running server for the path matches, such as by being in the same file or proxy
+// mode, it's provided instead with existing set.
+func (m *Server) addServer(path string, matches func(*fileserver.FileServer) bool, start func() (*fileserver.FileServer, error)) (fServer *fileserver.FileServer, existing bool, err error) {
+	rootPath, err := filepath.Abs(util.FormatRootPath(path))
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	m.FileServers = append(m.FileServers, *fServer)
-	logger.Display(fmt.Sprintf("Serving files from %s at http://localhost:%d", fServer.RootPath, fServer.Port))
 
-	m.watchFolder(fServer.RootPath)
-	return fServer, nil
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, fServer := range m.FileServers {
+		if fServer.RootPath == rootPath && matches(fServer) {
+			logger.Display("Server already running")
+			return fServer, true, nil
+		}
+	}
+
+	fServer, err = start()
+	if err != nil {
+		return nil, false, err
+	}
+	fServer.SetEventHandler(m.sendEvent)
+	fServer.SetDependencyHandler(func(dependency string) {
+		m.watchDependency(fServer, dependency)
+	})
+	m.FileServers = append(m.FileServers, fServer)
+
+	if err := m.watchFolder(fServer, fServer.RootPath); err != nil {
+		logger.Error("Watching server folder", err)
+	}
+	return fServer, false, nil
+}
+
+// removeFileServer destroys the given file server and removes the file watches no other server needs.
+// It returns false if the server had already been removed.
+func (m *Server) removeFileServer(server *fileserver.FileServer) bool {
+	m.mutex.Lock()
+	index := -1
+	for i, fServer := range m.FileServers {
+		if fServer == server {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		m.mutex.Unlock()
+		return false
+	}
+	m.FileServers = append(m.FileServers[:index], m.FileServers[index+1:]...)
+	m.unwatchServer(server)
+	m.mutex.Unlock()
+
+	server.Destroy()
+	m.serversChanged(fmt.Sprintf("Closed server for %s at %s", server.RootPath, server.Url()))
+	return true
+}
+
+// Servers provides a copy of the running file servers that is safe to range over.
+func (m *Server) Servers() []*fileserver.FileServer {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return append([]*fileserver.FileServer(nil), m.FileServers...)
 }
 
 // Listen starts the manager http server on the given port
@@ -63,25 +228,21 @@ func (m *Server) Listen() error {
 	m.NetworkIP = getLocalIP()
 	m.startFileWatcher()
 
-	handler := m.getManagerRouting()
-	go http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", m.Options.ManagerPort), handler)
-	m.startUI()
-	return nil
-}
-
-func (m *Server) findFileServerByPath(rootPath string) (*fileserver.FileServer, error) {
-	searchPath, err := filepath.Abs(rootPath)
+	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", m.Options.ManagerPort))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	for _, fServer := range m.FileServers {
-		if fServer.RootPath == searchPath {
-			return &fServer, nil
-		}
+	// Serve both http & https on the manager port so pages served over either can use livereload
+	if m.Options.TLSConfig != nil {
+		listener = certs.NewDualListener(listener, m.Options.TLSConfig)
 	}
 
-	return nil, errors.New("path not found")
+	handler := m.getManagerRouting()
+	go http.Serve(listener, handler)
+	go m.watchIdle()
+	m.startUI()
+	return nil
 }
 
 func (m *Server) sendReloadSignal(file string) {
@@ -90,19 +251,43 @@ func (m *Server) sendReloadSignal(file string) {
 		Path:    file,
 		LiveCSS: true,
 	}
-	for i := 0; i < len(m.sockets); i++ {
-		if !m.sockets[i].IsServerConn() {
-			m.sockets[i].Close()
+	for _, socket := range m.liveSockets() {
+		if !socket.IsServerConn() {
+			socket.Close()
 		}
 
-		if m.sockets[i].IsServerConn() {
-			websocket.JSON.Send(m.sockets[i], response)
+		if socket.IsServerConn() {
+			websocket.JSON.Send(socket, response)
 		}
 	}
 
 	logger.Devlog("File changed: " + file)
 }
 
+// sendEvent sends a named event, with detail data, over the livereload socket
+// to be dispatched as a DOM event by the webby events livereload plugin.
+func (m *Server) sendEvent(event string, detail interface{}) {
+	detailJSON, err := json.Marshal(detail)
+	if err != nil {
+		logger.Error("Encoding event detail", err)
+		return
+	}
+
+	response := livereloadChange{
+		Command:      "reload",
+		Path:         "webby-event:" + event,
+		OriginalPath: string(detailJSON),
+		LiveCSS:      true,
+	}
+	for _, socket := range m.liveSockets() {
+		if socket.IsServerConn() {
+			websocket.JSON.Send(socket, response)
+		}
+	}
+
+	logger.Devlog("Sent event: " + event)
+}
+
 func (m *Server) handleFileChange(filePath string) {
 
 	// Prevent duplicate changes
@@ -117,6 +302,14 @@ func (m *Server) handleFileChange(filePath string) {
 		return
 	}
 
+	// Ignore files written by webby itself
+	servers := m.Servers()
+	for _, fServer := range servers {
+		if fServer.IsInternalWrite(filePath) {
+			return
+		}
+	}
+
 	m.lastFileChange = currentTime
 	m.changedFiles <- filePath
 }
@@ -127,8 +320,11 @@ func (m *Server) startFileWatcher() error {
 		return err
 	}
 
+	m.mutex.Lock()
 	m.fileWatcher = watcher
 	m.changedFiles = make(chan string)
+	folders := append([]string(nil), m.WatchedFolders...)
+	m.mutex.Unlock()
 
 	// Process events
 	go func() {
@@ -144,9 +340,9 @@ func (m *Server) startFileWatcher() error {
 		}
 	}()
 
-	for i := 0; i < len(m.WatchedFolders); i++ {
-		err = watcher.Watch(m.WatchedFolders[i])
-		logger.Devlog("Adding file watcher to " + m.WatchedFolders[i])
+	for _, folder := range folders {
+		err = watcher.Watch(folder)
+		logger.Devlog("Adding file watcher to " + folder)
 		if err != nil {
 			return err
 		}
@@ -154,7 +350,8 @@ func (m *Server) startFileWatcher() error {
 
 	go func() {
 		for f := range m.changedFiles {
-			if len(m.sockets) > 0 {
+			m.publish(dashboardEvent{Type: "file-change", Message: "Changed " + f})
+			if len(m.liveSockets()) > 0 {
 				m.sendReloadSignal(f)
 			}
 		}
@@ -163,28 +360,143 @@ func (m *Server) startFileWatcher() error {
 	return nil
 }
 
-func (m *Server) watchFolder(folderPath string) error {
-	if !util.StringInSlice(folderPath, m.WatchedFolders) {
+// watchFolder watches the given folder for the given server, if not already
+// watched. The manager's mutex must be held.
+func (m *Server) watchFolder(server *fileserver.FileServer, folderPath string) error {
+	if m.watchers == nil {
+		m.watchers = make(map[string]map[int]bool)
+	}
+	if m.watchers[folderPath] == nil {
+		m.watchers[folderPath] = make(map[int]bool)
 		m.WatchedFolders = append(m.WatchedFolders, folderPath)
-		if m.fileWatcher == nil {
-			return nil
-		}
-		err := m.fileWatcher.Watch(folderPath)
-		logger.Devlog("Adding file watcher to " + folderPath)
-		if err != nil {
-			return err
+		if m.fileWatcher != nil {
+			logger.Devlog("Adding file watcher to " + folderPath)
+			if err := m.fileWatcher.Watch(folderPath); err != nil {
+				return err
+			}
 		}
 	}
+	m.watchers[folderPath][server.ID] = true
 	return nil
 }
 
-func (m *Server) findFileServerById(id int) (int, *fileserver.FileServer) {
-	for index, server := range m.FileServers {
+// unwatchServer removes the given server's file watches, stopping those no
+// other server needs. The manager's mutex must be held.
+func (m *Server) unwatchServer(server *fileserver.FileServer) {
+	for i := len(m.WatchedFolders) - 1; i >= 0; i-- {
+		folderPath := m.WatchedFolders[i]
+		delete(m.watchers[folderPath], server.ID)
+		if len(m.watchers[folderPath]) > 0 {
+			continue
+		}
+
+		delete(m.watchers, folderPath)
+		m.WatchedFolders = append(m.WatchedFolders[:i], m.WatchedFolders[i+1:]...)
+		if m.fileWatcher != nil {
+			m.fileWatcher.RemoveWatch(folderPath)
+		}
+	}
+}
+
+// watchDependency watches the folder of a file, such as an include or layout,
+// that a page of the given server was rendered from so pages reload when it changes.
+func (m *Server) watchDependency(server *fileserver.FileServer, path string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, fServer := range m.FileServers {
+		if fServer == server {
+			if err := m.watchFolder(server, filepath.Dir(path)); err != nil {
+				logger.Error("Watching page dependency", err)
+			}
+			return
+		}
+	}
+}
+
+func (m *Server) findFileServerById(id int) *fileserver.FileServer {
+	for _, server := range m.Servers() {
 		if server.ID == id {
-			return index, &server
+			return server
 		}
 	}
-	return -1, nil
+	return nil
+}
+
+// findFileServerForRequest finds the file server referenced by the id parameter of the given request
+func (m *Server) findFileServerForRequest(req *http.Request) (*fileserver.FileServer, error) {
+	idVal, err := strconv.Atoi(req.FormValue("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	server := m.findFileServerById(idVal)
+	if server == nil {
+		return nil, fmt.Errorf("fileserver with ID of %d not found", idVal)
+	}
+	return server, nil
+}
+
+// action restricts a manager route that changes servers to POST requests, so
+// following links or prefetching cannot trigger it, and to requests from the manager's
+// own pages, so other sites cannot submit forms to it, then tells dashboards of the change.
+func (m *Server) action(handle http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !isSameOrigin(req) {
+			logger.With(logger.Fields{"origin": req.Header.Get("Origin")}).Warn("Rejected a cross-origin manager request to " + req.URL.Path)
+			http.Error(w, "Cross-origin requests are not allowed", http.StatusForbidden)
+			return
+		}
+
+		handle(w, req)
+		m.serversChanged("")
+	}
+}
+
+// isSameOrigin checks the given request was not sent from another site, going by the
+// headers browsers add. Requests without them, such as from the webby command, are allowed.
+func isSameOrigin(req *http.Request) bool {
+	switch req.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "":
+	default:
+		return false
+	}
+
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	originURL, err := url.Parse(origin)
+	return err == nil && originURL.Host == req.Host
+}
+
+// isLoopbackRequest checks if the given request was made from this machine.
+func isLoopbackRequest(req *http.Request) bool {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// parseHeaderLines parses "Name: value" lines, as entered in the manager, into a map of headers
+func parseHeaderLines(text string) map[string]string {
+	headers := make(map[string]string)
+	for _, line := range strings.Split(text, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) != "" {
+			headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	return headers
 }
 
 func (m *Server) getManagerRouting() *http.ServeMux {
@@ -192,69 +504,438 @@ func (m *Server) getManagerRouting() *http.ServeMux {
 	handler := http.NewServeMux()
 
 	// Create new file server instance
-	handler.HandleFunc("/create-server", func(w http.ResponseWriter, req *http.Request) {
-		if req.Method == "POST" {
-			rootPath := req.FormValue("root_path")
-			fileServer, err := m.AddFileServer(rootPath)
+	handler.HandleFunc("/create-server", m.action(func(w http.ResponseWriter, req *http.Request) {
+		rootPath := req.FormValue("root_path")
+		proxyURL := req.FormValue("proxy_url")
 
-			if err != nil {
-				logger.Error("Create server handler", err)
-				return
-			}
+		// The webby command, run on this machine, can open any folder while other clients
+		// are kept to the same allowed roots as the manager page
+		if !isLoopbackRequest(req) && !util.IsPathAllowed(rootPath, m.Options.AllowedRoots) {
+			logger.Warn(fmt.Sprintf("Rejected creating a server for %q outside of the allowed roots", rootPath))
+			http.Error(w, "Folder is not within an allowed root", http.StatusForbidden)
+			return
+		}
 
-			err = json.NewEncoder(w).Encode(fileServer)
-			if err != nil {
-				logger.Error("Create server response encoder", err)
-			}
+		var fileServer *fileserver.FileServer
+		var err error
+		if proxyURL != "" {
+			fileServer, err = m.AddProxyServer(rootPath, proxyURL)
+		} else {
+			fileServer, err = m.AddFileServer(rootPath)
+		}
+
+		if err != nil {
+			logger.Error("Create server handler", err)
+			return
+		}
+
+		// Apply any idle timeout given to the webby command requesting the server
+		if minutes, err := strconv.Atoi(req.FormValue("idle_timeout")); err == nil && minutes > 0 {
+			fileServer.SetIdleTimeout(minutes)
 		}
-	})
+
+		err = json.NewEncoder(w).Encode(fileServer.Snapshot())
+		if err != nil {
+			logger.Error("Create server response encoder", err)
+		}
+	}))
 
 	// Delete a file server instance
-	handler.HandleFunc("/delete-server", func(w http.ResponseWriter, req *http.Request) {
-		id := req.URL.Query().Get("id")
-		idVal, err := strconv.Atoi(id)
+	handler.HandleFunc("/delete-server", m.action(func(w http.ResponseWriter, req *http.Request) {
+		idVal, err := strconv.Atoi(req.FormValue("id"))
 
 		if err != nil {
 			logger.Error("Delete server handler", err)
 			return
 		}
 
-		index, server := m.findFileServerById(idVal)
+		server := m.findFileServerById(idVal)
 		if server == nil {
 			err := fmt.Errorf("fileserver with ID of %d not found", idVal)
 			logger.Error("Delete server handler", err)
 			return
 		}
 
-		// Destroy server
-		server.Destroy()
-		m.fileWatcher.RemoveWatch(server.RootPath)
-		for i, path := range m.WatchedFolders {
-			if path == server.RootPath {
-				m.WatchedFolders = append(m.WatchedFolders[:i], m.WatchedFolders[i+1:]...)
-				break
-			}
-		}
-		m.FileServers = append(m.FileServers[:index], m.FileServers[index+1:]...)
-
+		m.removeFileServer(server)
 		logger.Devlog(fmt.Sprintf("Deleted server with id of %d", server.ID))
-		http.Redirect(w, req, "/", http.StatusTemporaryRedirect)
-	})
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
 
 	// Toggle livereload on/off
-	handler.HandleFunc("/toggle-livereload", func(w http.ResponseWriter, req *http.Request) {
+	handler.HandleFunc("/toggle-livereload", m.action(func(w http.ResponseWriter, req *http.Request) {
 		m.Options.LiveReloadEnabled = !m.Options.LiveReloadEnabled
-		http.Redirect(w, req, "/", http.StatusTemporaryRedirect)
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Toggle compression on/off for a file server
+	handler.HandleFunc("/toggle-compression", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Toggle compression handler", err)
+			return
+		}
+
+		server.ToggleCompressionEnabled()
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Toggle production preview mode, with minified responses, for a file server
+	handler.HandleFunc("/toggle-production-preview", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Toggle production preview handler", err)
+			return
+		}
+
+		server.ToggleProductionPreview()
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Toggle serving .avif and .webp image siblings to browsers that accept them
+	handler.HandleFunc("/toggle-modern-images", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Toggle modern images handler", err)
+			return
+		}
+
+		server.ToggleModernImages()
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Toggle writing an access log, in the Combined format, for a file server
+	handler.HandleFunc("/toggle-access-log", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Toggle access log handler", err)
+			return
+		}
+
+		err = server.ToggleAccessLog()
+		if err != nil {
+			logger.Error("Toggle access log handler", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Set how many minutes a file server is kept running for while idle
+	handler.HandleFunc("/set-idle-timeout", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Set idle timeout handler", err)
+			return
+		}
+
+		minutes := 0
+		if value := req.FormValue("minutes"); value != "" {
+			minutes, err = strconv.Atoi(value)
+			if err != nil || minutes < 0 {
+				logger.Error("Set idle timeout handler", fmt.Errorf("invalid idle timeout %q", value))
+				http.Error(w, "Idle timeout must be a whole number of minutes", http.StatusBadRequest)
+				return
+			}
+		}
+		server.SetIdleTimeout(minutes)
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Add a path based proxy rule to a file server
+	handler.HandleFunc("/add-proxy-rule", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Add proxy rule handler", err)
+			return
+		}
+
+		rule := &fileserver.ProxyRule{
+			Prefix:   req.FormValue("prefix"),
+			Upstream: req.FormValue("upstream"),
+			Rewrite:  req.FormValue("rewrite"),
+			Headers:  parseHeaderLines(req.FormValue("headers")),
+		}
+		err = server.AddProxyRule(rule)
+		if err != nil {
+			logger.Error("Add proxy rule handler", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Remove a path based proxy rule from a file server
+	handler.HandleFunc("/delete-proxy-rule", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Delete proxy rule handler", err)
+			return
+		}
+
+		index, err := strconv.Atoi(req.FormValue("index"))
+		if err == nil {
+			err = server.RemoveProxyRule(index)
+		}
+		if err != nil {
+			logger.Error("Delete proxy rule handler", err)
+			return
+		}
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Enable, change or disable the mock REST API of a file server
+	handler.HandleFunc("/set-mock-api", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Set mock API handler", err)
+			return
+		}
+
+		var config *fileserver.MockAPIConfig
+		if prefix := req.FormValue("prefix"); prefix != "" {
+			config = &fileserver.MockAPIConfig{Prefix: prefix, File: req.FormValue("file")}
+		}
+		err = server.SetMockAPI(config)
+		if err != nil {
+			logger.Error("Set mock API handler", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Enable, change or disable the serverless functions of a file server
+	handler.HandleFunc("/set-functions", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Set functions handler", err)
+			return
+		}
+
+		var config *fileserver.FunctionsConfig
+		if req.FormValue("enabled") != "" {
+			config = &fileserver.FunctionsConfig{Dir: req.FormValue("dir"), Prefix: req.FormValue("prefix")}
+		}
+		err = server.SetFunctions(config)
+		if err != nil {
+			logger.Error("Set functions handler", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Set, change or disable the simulated network conditions of a file server
+	handler.HandleFunc("/set-throttle", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Set throttle handler", err)
+			return
+		}
+
+		var config *fileserver.ThrottleConfig
+		if profile := req.FormValue("profile"); profile != "" {
+			config = &fileserver.ThrottleConfig{
+				Profile:      profile,
+				Paths:        splitList(req.FormValue("paths")),
+				ContentTypes: splitList(req.FormValue("content_types")),
+			}
+			config.Latency, _ = strconv.Atoi(req.FormValue("latency"))
+			config.Bandwidth, _ = strconv.Atoi(req.FormValue("bandwidth"))
+			config.Jitter, _ = strconv.Atoi(req.FormValue("jitter"))
+		}
+		err = server.SetThrottle(config)
+		if err != nil {
+			logger.Error("Set throttle handler", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Add a rule that fails matching requests to a file server
+	handler.HandleFunc("/add-fault-rule", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Add fault rule handler", err)
+			return
+		}
+
+		rule := &fileserver.FaultRule{
+			Path: strings.TrimSpace(req.FormValue("path")),
+			Type: req.FormValue("type"),
+		}
+		rule.Status, _ = strconv.Atoi(req.FormValue("status"))
+		rule.Stall, _ = strconv.Atoi(req.FormValue("stall"))
+		rule.Percent, _ = strconv.ParseFloat(req.FormValue("percent"), 64)
+		rule.Seed, _ = strconv.ParseInt(req.FormValue("seed"), 10, 64)
+		first := len(server.Snapshot().FaultRules) == 0
+		err = server.AddFaultRule(rule)
+		if err != nil {
+			logger.Error("Add fault rule handler", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Start injecting faults with the first rule, as otherwise adding it would appear to do nothing
+		if first {
+			server.SetFaultsEnabled(true)
+		}
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Remove a fault rule from a file server
+	handler.HandleFunc("/delete-fault-rule", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Delete fault rule handler", err)
+			return
+		}
+
+		index, err := strconv.Atoi(req.FormValue("index"))
+		if err == nil {
+			err = server.RemoveFaultRule(index)
+		}
+		if err != nil {
+			logger.Error("Delete fault rule handler", err)
+			return
+		}
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Toggle fault injection on/off for a file server, keeping its rules
+	handler.HandleFunc("/toggle-faults", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Toggle faults handler", err)
+			return
+		}
+
+		server.ToggleFaultsEnabled()
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Set the entry points bundled, and JSX options used, when transpiling for a file server
+	handler.HandleFunc("/set-transpile", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Set transpile handler", err)
+			return
+		}
+
+		config := &fileserver.TranspileConfig{
+			Bundle:          splitList(req.FormValue("bundle")),
+			JSXImportSource: strings.TrimSpace(req.FormValue("jsx_import_source")),
+		}
+		err = server.SetTranspile(config)
+		if err != nil {
+			logger.Error("Set transpile handler", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Remove a script handler from a file server
+	handler.HandleFunc("/delete-script-handler", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Delete script handler", err)
+			return
+		}
+
+		index, err := strconv.Atoi(req.FormValue("index"))
+		if err == nil {
+			err = server.RemoveScriptHandler(index)
+		}
+		if err != nil {
+			logger.Error("Delete script handler", err)
+			return
+		}
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Add a mock WebSocket or Server-Sent Events endpoint to a file server
+	handler.HandleFunc("/add-realtime-endpoint", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Add realtime endpoint handler", err)
+			return
+		}
+
+		endpoint := &fileserver.RealtimeEndpoint{
+			Type: req.FormValue("type"),
+			Path: strings.TrimSpace(req.FormValue("path")),
+			File: strings.TrimSpace(req.FormValue("file")),
+			Loop: req.FormValue("loop") != "",
+		}
+		err = server.AddRealtimeEndpoint(endpoint)
+		if err != nil {
+			logger.Error("Add realtime endpoint handler", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Remove a mock realtime endpoint from a file server
+	handler.HandleFunc("/delete-realtime-endpoint", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Delete realtime endpoint handler", err)
+			return
+		}
+
+		index, err := strconv.Atoi(req.FormValue("index"))
+		if err == nil {
+			err = server.RemoveRealtimeEndpoint(index)
+		}
+		if err != nil {
+			logger.Error("Delete realtime endpoint handler", err)
+			return
+		}
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Download the development certificate authority for installing on devices
+	handler.HandleFunc("/webby-ca.crt", func(w http.ResponseWriter, req *http.Request) {
+		if m.authority == nil {
+			http.NotFound(w, req)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"webby-ca.crt\"")
+		w.Write(m.authority.CertPEM)
 	})
 
 	// Load compiled in static content
 	fileBox := rice.MustFindBox("../../res")
+	m.addFixtureRoutes(handler, fileBox)
+	m.addFormRoutes(handler, fileBox)
+	m.addInspectorRoutes(handler, fileBox)
+	m.addMetricsRoutes(handler)
+	m.addEventRoutes(handler)
+	m.addServerSetupRoutes(handler)
 
 	// Get manager homepage
 	handler.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		templString := fileBox.MustString("index.html")
 		templ, _ := template.New("Home").Parse(templString)
-		templ.Execute(w, m)
+		templ.Execute(w, indexPage{Server: m, ServerViews: m.serverViews()})
 	})
 
 	// Static file serving
@@ -263,6 +944,7 @@ func (m *Server) getManagerRouting() *http.ServeMux {
 
 	// Get LiveReload Script
 	handler.Handle("/livereload.js", http.FileServer(fileBox.HTTPBox()))
+	handler.Handle("/webby-events.js", http.FileServer(fileBox.HTTPBox()))
 
 	// Websocket handling
 	wsHandler := m.getLivereloadWsHandler()
@@ -271,6 +953,29 @@ func (m *Server) getManagerRouting() *http.ServeMux {
 	return handler
 }
 
+// indexPage is the data the manager page is rendered with.
+type indexPage struct {
+	*Server
+	ServerViews []*serverView
+}
+
+// serverView is a snapshot of the settings and statistics of a file server shown on the manager page.
+type serverView struct {
+	fileserver.ServerSettings
+	Stats       fileserver.ServerStats
+	MinifyStats []*fileserver.MinifyStat
+}
+
+// serverViews takes a snapshot of each running file server for the manager page.
+func (m *Server) serverViews() []*serverView {
+	servers := m.Servers()
+	views := make([]*serverView, 0, len(servers))
+	for _, server := range servers {
+		views = append(views, &serverView{ServerSettings: server.Snapshot(), Stats: server.Stats(), MinifyStats: server.MinifyStats()})
+	}
+	return views
+}
+
 type livereloadResponse struct {
 	Command string `json:"command"`
 }
@@ -282,15 +987,23 @@ type livereloadHello struct {
 }
 
 type livereloadChange struct {
-	Command string `json:"command"`
-	Path    string `json:"path"`
-	LiveCSS bool   `json:"liveCSS"`
+	Command      string `json:"command"`
+	Path         string `json:"path"`
+	OriginalPath string `json:"originalPath,omitempty"`
+	LiveCSS      bool   `json:"liveCSS"`
 }
 
 func (m *Server) getLivereloadWsHandler() func(ws *websocket.Conn) {
 	return func(ws *websocket.Conn) {
 
-		m.sockets = append(m.sockets, ws)
+		m.addSocket(ws)
+		defer m.removeSocket(ws)
+
+		// Count the client against the server hosting the page it connected from
+		if server := m.findFileServerByOrigin(ws.Request().Header.Get("Origin")); server != nil {
+			server.AddLiveReloadClient(1)
+			defer server.AddLiveReloadClient(-1)
+		}
 
 		for {
 			// websocket.Message.Send(ws, "Hello, Client!")
@@ -323,6 +1036,48 @@ func (m *Server) getLivereloadWsHandler() func(ws *websocket.Conn) {
 
 }
 
+func (m *Server) addSocket(ws *websocket.Conn) {
+	m.socketMutex.Lock()
+	m.sockets = append(m.sockets, ws)
+	m.socketMutex.Unlock()
+}
+
+func (m *Server) removeSocket(ws *websocket.Conn) {
+	m.socketMutex.Lock()
+	defer m.socketMutex.Unlock()
+	for i, socket := range m.sockets {
+		if socket == ws {
+			m.sockets = append(m.sockets[:i], m.sockets[i+1:]...)
+			return
+		}
+	}
+}
+
+// liveSockets provides a copy of the connected livereload sockets that is safe to range over.
+func (m *Server) liveSockets() []*websocket.Conn {
+	m.socketMutex.Lock()
+	defer m.socketMutex.Unlock()
+	return append([]*websocket.Conn(nil), m.sockets...)
+}
+
+// findFileServerByOrigin finds the file server listening on the port of the given origin URL.
+func (m *Server) findFileServerByOrigin(origin string) *fileserver.FileServer {
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return nil
+	}
+	port, err := strconv.Atoi(originURL.Port())
+	if err != nil {
+		return nil
+	}
+	for _, server := range m.Servers() {
+		if server.Port == port {
+			return server
+		}
+	}
+	return nil
+}
+
 func getLocalIP() string {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
@@ -1,17 +1,26 @@
 package manager
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/ssddanbrown/webby/internal/util"
 )
 
 func getTestServer() (*Server, *httptest.Server) {
 	m := new(Server)
+	m.Options = &util.Options{LiveReloadEnabled: true, ManagerPort: 35729}
 	m.startFileWatcher()
 	handler := m.getManagerRouting()
 	server := httptest.NewServer(handler)
@@ -55,6 +64,77 @@ func TestCreateServerRequest(t *testing.T) {
 	if m.FileServers[0].RootPath != tempDir {
 		t.Error("New fileserver path is incorrect")
 	}
+
+	// Other machines are kept to the allowed roots
+	otherDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(otherDir)
+	m.Options.AllowedRoots = []string{tempDir}
+	for _, rootPath := range []string{otherDir, filepath.Join(tempDir, "..")} {
+		req := httptest.NewRequest("POST", "/create-server", strings.NewReader(url.Values{"root_path": {rootPath}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "192.168.1.20:50000"
+		recorder := httptest.NewRecorder()
+		m.getManagerRouting().ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusForbidden || len(m.Servers()) != 1 {
+			t.Errorf("Expected a remote request for %s to be rejected, got %d", rootPath, recorder.Code)
+		}
+	}
+}
+
+func TestIndexPage(t *testing.T) {
+	m, server := getTestServer()
+	defer server.Close()
+
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+	fServer, err := m.AddFileServer(tempDir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.removeFileServer(fServer)
+
+	http.PostForm(server.URL+"/toggle-compression", url.Values{"id": {strconv.Itoa(fServer.ID)}})
+	http.PostForm(server.URL+"/toggle-access-log", url.Values{"id": {strconv.Itoa(fServer.ID)}})
+	settings := fServer.Snapshot()
+	if settings.CompressionEnabled || settings.AccessLog == nil {
+		t.Errorf("Expected compression to be toggled off and the access log on, got %+v", settings)
+	}
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), fServer.Url()) || !strings.Contains(string(body), "</html>") {
+		t.Errorf("Expected the manager page to list the server in full, got %s", body)
+	}
+}
+
+func TestAddServerMatchesMode(t *testing.T) {
+	m, server := getTestServer()
+	defer server.Close()
+
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+
+	fileServer, _ := m.AddFileServer(tempDir)
+	defer fileServer.Destroy()
+	proxyServer, _ := m.AddProxyServer(tempDir, "http://localhost:1")
+	defer proxyServer.Destroy()
+	if proxyServer == fileServer || proxyServer.ProxyURL != "http://localhost:1" {
+		t.Fatal("Expected a proxy server to be started alongside the file server")
+	}
+
+	if again, _ := m.AddFileServer(tempDir); again != fileServer {
+		t.Error("Expected the running file server to be provided")
+	}
+	if again, _ := m.AddProxyServer(tempDir, "http://localhost:2"); again != proxyServer {
+		t.Error("Expected the running proxy server to be provided")
+	}
+	if len(m.Servers()) != 2 {
+		t.Errorf("Expected only two servers to be running, got %d", len(m.Servers()))
+	}
 }
 
 func TestDeleteServerRequest(t *testing.T) {
@@ -81,6 +161,11 @@ func TestDeleteServerRequest(t *testing.T) {
 	fileServerId := m.FileServers[0].ID
 
 	resp, err = http.Get(server.URL + fmt.Sprintf("/delete-server?id=%d", fileServerId))
+	if err != nil || resp.StatusCode != http.StatusMethodNotAllowed || len(m.FileServers) != 1 {
+		t.Fatal("Expected a GET request not to delete the server")
+	}
+
+	resp, err = http.PostForm(server.URL+"/delete-server", url.Values{"id": {strconv.Itoa(fileServerId)}})
 	if err != nil {
 		t.Fatal(err.Error())
 	} else if resp.StatusCode != http.StatusOK {
@@ -100,3 +185,287 @@ func TestDeleteServerRequest(t *testing.T) {
 	}
 
 }
+
+func TestCrossOriginRequestsRejected(t *testing.T) {
+	m, server := getTestServer()
+	defer server.Close()
+
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+
+	post := func(headers map[string]string) *http.Response {
+		req, _ := http.NewRequest("POST", server.URL+"/create-server", strings.NewReader(url.Values{"root_path": {tempDir}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	for _, headers := range []map[string]string{
+		{"Origin": "http://evil.example"},
+		{"Origin": "null"},
+		{"Sec-Fetch-Site": "cross-site", "Origin": server.URL},
+		{"Sec-Fetch-Site": "same-site"},
+	} {
+		if resp := post(headers); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected a cross-origin request with %v to be rejected, got %d", headers, resp.StatusCode)
+		}
+	}
+	if len(m.Servers()) != 0 {
+		t.Fatal("Expected no server to be created by cross-origin requests")
+	}
+
+	if resp := post(map[string]string{"Origin": server.URL, "Sec-Fetch-Site": "same-origin"}); resp.StatusCode != http.StatusOK || len(m.Servers()) != 1 {
+		t.Errorf("Expected a request from the manager page to be allowed, got %d", resp.StatusCode)
+	}
+	m.removeFileServer(m.Servers()[0])
+}
+
+func TestDeleteFixtureRequest(t *testing.T) {
+	m, server := getTestServer()
+	defer server.Close()
+
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+	fServer, _ := m.AddFileServer(tempDir)
+	defer fServer.Destroy()
+
+	os.MkdirAll(fServer.FixturePath(), 0755)
+	fixturePath := filepath.Join(fServer.FixturePath(), "get-test.json")
+	ioutil.WriteFile(fixturePath, []byte("{}"), 0644)
+
+	resp, err := http.Get(server.URL + fmt.Sprintf("/delete-fixture?id=%d&file=get-test.json", fServer.ID))
+	if _, statErr := os.Stat(fixturePath); err != nil || resp.StatusCode != http.StatusMethodNotAllowed || statErr != nil {
+		t.Fatal("Expected a GET request not to delete the fixture")
+	}
+
+	resp, err = http.PostForm(server.URL+"/delete-fixture", url.Values{"id": {strconv.Itoa(fServer.ID)}, "file": {"get-test.json"}})
+	if err != nil || resp.Request.URL.Path != "/fixtures" {
+		t.Error("Expected deleting a fixture to redirect to the fixtures page")
+	}
+	if _, err := os.Stat(fixturePath); err == nil {
+		t.Error("The fixture was not deleted")
+	}
+}
+
+func TestMetricsRequest(t *testing.T) {
+	m, server := getTestServer()
+	defer server.Close()
+
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+
+	fServer, err := m.AddFileServer(tempDir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer fServer.Destroy()
+	fServer.AddLiveReloadClient(2)
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	metrics := string(body)
+	labels := fmt.Sprintf(`server="%d",port="%d",root="%s"`, fServer.ID, fServer.Port, tempDir)
+	if !strings.Contains(metrics, "# TYPE webby_http_requests_total counter") || !strings.Contains(metrics, "webby_servers 1\n") {
+		t.Errorf("Expected Prometheus metric headers, got %s", metrics)
+	}
+	if !strings.Contains(metrics, "webby_livereload_clients{"+labels+"} 2\n") {
+		t.Errorf("Expected live reload clients for the server, got %s", metrics)
+	}
+}
+
+func TestIdleServersClosed(t *testing.T) {
+	m, server := getTestServer()
+	defer server.Close()
+	m.Options.ManagerIdleTimeout = 10
+
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+
+	fServer, err := m.AddFileServer(tempDir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	fServer.SetIdleTimeout(5)
+
+	now := time.Now()
+	if m.checkIdle(now) || len(m.FileServers) != 1 {
+		t.Fatal("Expected an active server to be kept running")
+	}
+	if m.checkIdle(now.Add(6*time.Minute)) || len(m.FileServers) != 0 || len(m.WatchedFolders) != 0 {
+		t.Fatal("Expected the idle server and its watch to be removed")
+	}
+	if m.checkIdle(now.Add(15 * time.Minute)) {
+		t.Error("Expected the manager to wait its idle timeout from when it had no servers")
+	}
+	if !m.checkIdle(now.Add(16 * time.Minute)) {
+		t.Error("Expected the manager to stop after having no servers for its idle timeout")
+	}
+}
+
+func TestDashboardEvents(t *testing.T) {
+	m, server := getTestServer()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %s", resp.Header.Get("Content-Type"))
+	}
+
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+	fServer, err := m.AddFileServer(tempDir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer fServer.Destroy()
+
+	// Skip the initial history and stats events to find the new server
+	reader := bufio.NewReader(resp.Body)
+	for i := 0; i < 3; i++ {
+		line, err := reader.ReadString('\n')
+		for err == nil && line == "\n" {
+			line, err = reader.ReadString('\n')
+		}
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if strings.Contains(line, `"type":"servers"`) && strings.Contains(line, tempDir) {
+			return
+		}
+	}
+	t.Error("Expected a servers event for the new server")
+}
+
+func TestNewServerRequest(t *testing.T) {
+	m, server := getTestServer()
+	defer server.Close()
+
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+	sitePath := filepath.Join(tempDir, "site")
+	os.Mkdir(sitePath, 0755)
+	m.Options.AllowedRoots = []string{tempDir}
+
+	resp, err := http.Get(server.URL + "/browse?path=" + url.QueryEscape(tempDir))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var listing folderListing
+	json.NewDecoder(resp.Body).Decode(&listing)
+	resp.Body.Close()
+	if len(listing.Folders) != 1 || listing.Folders[0].Path != sitePath || listing.Parent != "" {
+		t.Errorf("Expected only the site folder to be listed, got %+v", listing)
+	}
+	if resp, _ := http.Get(server.URL + "/browse?path=" + url.QueryEscape(filepath.Dir(tempDir))); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected browsing outside of the allowed roots to be forbidden, got %d", resp.StatusCode)
+	}
+
+	resp, _ = http.PostForm(server.URL+"/new-server", url.Values{"root_path": {filepath.Dir(tempDir)}})
+	if resp.StatusCode != http.StatusBadRequest || len(m.FileServers) != 0 {
+		t.Fatal("Expected a server outside of the allowed roots not to be created")
+	}
+
+	resp, err = http.PostForm(server.URL+"/new-server", url.Values{"root_path": {sitePath}, "idle_timeout": {"5"}, "modern_images": {"1"}})
+	if err != nil || len(m.FileServers) != 1 {
+		t.Fatal("Expected a server to be created for the site folder")
+	}
+	fServer := m.FileServers[0]
+	defer fServer.Destroy()
+	if fServer.RootPath != sitePath || fServer.CompressionEnabled || !fServer.ModernImages || fServer.IdleTimeout != 5 {
+		t.Errorf("Expected the new server to use the given settings, got %+v", fServer)
+	}
+
+	resp, _ = http.PostForm(server.URL+"/new-server", url.Values{"root_path": {sitePath}, "port": {"0"}})
+	if resp.StatusCode != http.StatusConflict || len(m.FileServers) != 1 || !fServer.ModernImages {
+		t.Errorf("Expected a second server for the site folder to be refused, got status %d", resp.StatusCode)
+	}
+
+	otherPath := filepath.Join(tempDir, "other")
+	os.Mkdir(otherPath, 0755)
+	resp, _ = http.PostForm(server.URL+"/new-server", url.Values{"root_path": {otherPath}, "access_log": {"1"}, "access_log_file": {"../../../webby-outside.log"}})
+	if resp.StatusCode != http.StatusBadRequest || len(m.FileServers) != 1 {
+		t.Errorf("Expected a new server with invalid settings to be closed, got status %d with %d servers", resp.StatusCode, len(m.FileServers))
+	}
+
+	http.PostForm(server.URL+"/update-server", url.Values{"id": {strconv.Itoa(fServer.ID)}, "compression": {"1"}})
+	if !fServer.CompressionEnabled || fServer.ModernImages || fServer.IdleTimeout != 0 {
+		t.Errorf("Expected the server settings to be updated, got %+v", fServer)
+	}
+
+	outsideLog := filepath.Join(filepath.Dir(tempDir), "webby-outside.log")
+	for _, file := range []string{outsideLog, "../../webby-outside.log"} {
+		resp, _ = http.PostForm(server.URL+"/update-server", url.Values{"id": {strconv.Itoa(fServer.ID)}, "access_log": {"1"}, "access_log_file": {file}})
+		if resp.StatusCode != http.StatusBadRequest || fServer.AccessLog != nil {
+			t.Errorf("Expected access log file %s outside of the allowed roots to be refused", file)
+		}
+	}
+	if _, err := os.Stat(outsideLog); err == nil {
+		os.Remove(outsideLog)
+		t.Error("Expected no access log to be written outside of the allowed roots")
+	}
+}
+
+func TestAddFaultRuleRequest(t *testing.T) {
+	m, server := getTestServer()
+	defer server.Close()
+
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+	fServer, _ := m.AddFileServer(tempDir)
+	defer fServer.Destroy()
+
+	rule := url.Values{"id": {strconv.Itoa(fServer.ID)}, "path": {"*.json"}, "type": {"status"}, "percent": {"100"}}
+	http.PostForm(server.URL+"/add-fault-rule", rule)
+	if len(fServer.FaultRules) != 1 || !fServer.FaultsEnabled {
+		t.Fatal("Expected fault injection to be enabled by adding the first rule")
+	}
+
+	fServer.SetFaultsEnabled(false)
+	http.PostForm(server.URL+"/add-fault-rule", rule)
+	if len(fServer.FaultRules) != 2 || fServer.FaultsEnabled {
+		t.Error("Expected adding further rules to leave fault injection disabled")
+	}
+}
+
+func TestRemovingServerKeepsSharedWatches(t *testing.T) {
+	m, server := getTestServer()
+	defer server.Close()
+
+	tempDir, _ := ioutil.TempDir("", "webby-test")
+	defer os.RemoveAll(tempDir)
+	sharedPath := filepath.Join(tempDir, "shared")
+	os.Mkdir(sharedPath, 0755)
+
+	first, _ := m.AddFileServer(tempDir)
+	second, _ := m.AddFileServer(sharedPath)
+	m.watchDependency(first, filepath.Join(sharedPath, "layout.html"))
+
+	m.removeFileServer(second)
+	if !util.StringInSlice(sharedPath, m.WatchedFolders) {
+		t.Error("Expected a folder still used by another server to stay watched")
+	}
+
+	m.removeFileServer(first)
+	if len(m.WatchedFolders) != 0 {
+		t.Errorf("Expected all watches to be removed with their servers, got %v", m.WatchedFolders)
+	}
+	if m.removeFileServer(first) {
+		t.Error("Expected removing a server twice to do nothing")
+	}
+}
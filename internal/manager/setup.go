@@ -0,0 +1,232 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ssddanbrown/webby/internal/fileserver"
+	"github.com/ssddanbrown/webby/internal/logger"
+	"github.com/ssddanbrown/webby/internal/util"
+)
+
+// folderListing is the content of a folder shown by the manager's folder browser.
+type folderListing struct {
+	Path    string        `json:"path"`
+	Parent  string        `json:"parent,omitempty"`
+	Folders []folderEntry `json:"folders"`
+	Roots   []string      `json:"roots"`
+}
+
+type folderEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// addServerSetupRoutes adds the routes used to create and edit servers from the manager page
+func (m *Server) addServerSetupRoutes(handler *http.ServeMux) {
+
+	// List the sub-folders of a folder within the allowed roots, for choosing a folder to serve
+	handler.HandleFunc("/browse", func(w http.ResponseWriter, req *http.Request) {
+		roots := m.Options.AllowedRoots
+		if roots == nil {
+			roots = []string{}
+		}
+
+		path := req.FormValue("path")
+		if path == "" && len(roots) > 0 {
+			path = roots[0]
+		}
+
+		listing := folderListing{Path: path, Folders: []folderEntry{}, Roots: roots}
+		if path != "" {
+			if !util.IsPathAllowed(path, roots) {
+				http.Error(w, "Folder is not within an allowed root", http.StatusForbidden)
+				return
+			}
+
+			var err error
+			listing.Folders, err = listFolders(path, roots)
+			if err != nil {
+				logger.Error("Browse folders handler", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if parent := filepath.Dir(path); parent != path && util.IsPathAllowed(parent, roots) {
+				listing.Parent = parent
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listing)
+	})
+
+	// Start a new server, for a folder within the allowed roots, with the given port and settings
+	handler.HandleFunc("/new-server", m.action(func(w http.ResponseWriter, req *http.Request) {
+		rootPath := strings.TrimSpace(req.FormValue("root_path"))
+		err := m.checkNewServerPath(rootPath)
+
+		port := 0
+		if value := req.FormValue("port"); err == nil && value != "" {
+			port, err = strconv.Atoi(value)
+			if err != nil {
+				err = fmt.Errorf("invalid port %q", value)
+			}
+		}
+
+		var server *fileserver.FileServer
+		var existing bool
+		if err == nil {
+			if proxyURL := strings.TrimSpace(req.FormValue("proxy_url")); proxyURL != "" {
+				server, existing, err = m.addProxyServer(rootPath, proxyURL, port)
+			} else {
+				server, existing, err = m.addFileServer(rootPath, port)
+			}
+		}
+		if existing {
+			// Leave the running server as it is, rather than changing its settings
+			http.Error(w, fmt.Sprintf("A server for %s is already running at %s", server.RootPath, server.Url()), http.StatusConflict)
+			return
+		}
+		if err == nil {
+			// Close the new server, rather than leave it half configured, if its settings are invalid
+			if err = m.applyServerSettings(server, req); err != nil {
+				m.removeFileServer(server)
+			}
+		}
+		if err != nil {
+			logger.Error("New server handler", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+
+	// Change the settings of a running server
+	handler.HandleFunc("/update-server", m.action(func(w http.ResponseWriter, req *http.Request) {
+		server, err := m.findFileServerForRequest(req)
+		if err != nil {
+			logger.Error("Update server handler", err)
+			return
+		}
+
+		err = m.applyServerSettings(server, req)
+		if err != nil {
+			logger.Error("Update server handler", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	}))
+}
+
+// checkNewServerPath checks the given path is a folder that servers can be started for from the manager page.
+func (m *Server) checkNewServerPath(rootPath string) error {
+	if !filepath.IsAbs(rootPath) {
+		return fmt.Errorf("folder %q must be an absolute path", rootPath)
+	}
+	if !util.IsPathAllowed(rootPath, m.Options.AllowedRoots) {
+		return fmt.Errorf("folder %q is not within an allowed root", rootPath)
+	}
+	info, err := os.Stat(rootPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%q is not a folder", rootPath)
+	}
+	return nil
+}
+
+// checkAccessLogFile checks the given access log file, relative to the server folder unless absolute,
+// is within the server folder or the allowed roots so the manager page cannot write files elsewhere.
+func (m *Server) checkAccessLogFile(server *fileserver.FileServer, file string) error {
+	fPath := file
+	if !filepath.IsAbs(fPath) {
+		fPath = filepath.Join(server.RootPath, fPath)
+	}
+
+	// The file, and its folders, may not exist yet so check the nearest that does
+	existing := filepath.Clean(fPath)
+	for {
+		if _, err := os.Lstat(existing); err == nil || filepath.Dir(existing) == existing {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+
+	roots := append([]string{server.RootPath}, m.Options.AllowedRoots...)
+	if !util.IsPathAllowed(existing, roots) {
+		return fmt.Errorf("access log file %q must be within the server folder or an allowed root", file)
+	}
+	return nil
+}
+
+// listFolders lists the visible sub-folders of the given folder that are within the given roots.
+func listFolders(path string, roots []string) ([]folderEntry, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	folders := []folderEntry{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		folderPath := filepath.Join(path, entry.Name())
+		// Follow symlinks, while keeping to folders within the roots
+		info, err := os.Stat(folderPath)
+		if err != nil || !info.IsDir() || !util.IsPathAllowed(folderPath, roots) {
+			continue
+		}
+		folders = append(folders, folderEntry{Name: entry.Name(), Path: folderPath})
+	}
+	return folders, nil
+}
+
+// applyServerSettings changes the settings of a server to those given by the
+// new server or edit server forms of the manager page.
+func (m *Server) applyServerSettings(server *fileserver.FileServer, req *http.Request) error {
+	idleTimeout := 0
+	if value := req.FormValue("idle_timeout"); value != "" {
+		var err error
+		idleTimeout, err = strconv.Atoi(value)
+		if err != nil || idleTimeout < 0 {
+			return fmt.Errorf("invalid idle timeout %q", value)
+		}
+	}
+
+	accessLogFile := strings.TrimSpace(req.FormValue("access_log_file"))
+	if accessLogFile != "" {
+		if err := m.checkAccessLogFile(server, accessLogFile); err != nil {
+			return err
+		}
+	}
+	settings := server.Snapshot()
+	if req.FormValue("access_log") == "" {
+		if settings.AccessLog != nil {
+			if err := server.SetAccessLog(nil); err != nil {
+				return err
+			}
+		}
+	} else if settings.AccessLog == nil || (accessLogFile != "" && accessLogFile != settings.AccessLog.File) {
+		if err := server.SetAccessLog(&fileserver.AccessLogConfig{File: accessLogFile}); err != nil {
+			return err
+		}
+	}
+
+	server.SetCompressionEnabled(req.FormValue("compression") != "")
+	if preview := req.FormValue("production_preview") != ""; preview != settings.ProductionPreview {
+		server.SetProductionPreview(preview)
+	}
+	server.SetModernImages(req.FormValue("modern_images") != "")
+	server.SetIdleTimeout(idleTimeout)
+	return nil
+}
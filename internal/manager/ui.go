@@ -1,5 +1,5 @@
 package manager
 
 func (m *Server) startUI() {
-	select {}
+	<-m.shutdown
 }
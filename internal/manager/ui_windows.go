@@ -77,6 +77,12 @@ func (m *Server) setupTrayIcon() {
 		log.Fatal(err)
 	}
 
+	// Exit once the manager has been stopped, such as after being idle.
+	go func() {
+		<-m.shutdown
+		walk.App().Exit(0)
+	}()
+
 	// Run the message loop.
 	mw.Run()
 }
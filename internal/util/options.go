@@ -1,6 +1,13 @@
 package util
 
+import "crypto/tls"
+
 type Options struct {
-	LiveReloadEnabled bool
-	ManagerPort       int
+	LiveReloadEnabled  bool
+	ManagerPort        int
+	HTTPSEnabled       bool
+	TLSConfig          *tls.Config
+	IdleTimeout        int      // Minutes new servers are kept running for while idle, zero for no limit
+	ManagerIdleTimeout int      // Minutes the manager is kept running for with no servers, zero for no limit
+	AllowedRoots       []string // Folders, and their sub-folders, that can be browsed and served from the manager page
 }
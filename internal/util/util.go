@@ -15,6 +15,14 @@ func IsHTMLFile(path string) bool {
 	return htmlExts[ext]
 }
 
+// IsMarkdownFile checks if the given path indicates a markdown file type
+func IsMarkdownFile(path string) bool {
+	exts := strings.Split(path, ".")
+	ext := strings.ToLower(exts[len(exts)-1])
+	markdownExts := map[string]bool{"md": true, "markdown": true}
+	return markdownExts[ext]
+}
+
 // StringInSlice checks if the given str exists within the given list slice.
 func StringInSlice(str string, list []string) bool {
 	for _, v := range list {
@@ -46,3 +54,33 @@ func FormatRootPath(path string) string {
 
 	return path
 }
+
+// IsPathAllowed checks if the given path is, or is within, one of the given root folders.
+// Symlinks are resolved first so they cannot be used to reach outside of the roots.
+func IsPathAllowed(path string, roots []string) bool {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return false
+	}
+
+	for _, root := range roots {
+		rootPath, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			continue
+		}
+		rootPath, err = filepath.Abs(rootPath)
+		if err != nil {
+			continue
+		}
+
+		relative, err := filepath.Rel(rootPath, resolved)
+		if err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
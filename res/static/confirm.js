@@ -0,0 +1,7 @@
+// Ask for confirmation before submitting destructive actions marked with data-confirm
+document.addEventListener('submit', function(event) {
+	var message = event.target.getAttribute('data-confirm');
+	if (message && !confirm(message)) {
+		event.preventDefault();
+	}
+});
@@ -0,0 +1,63 @@
+/**
+ * LiveReload plugin that turns webby event messages, sent over the
+ * livereload socket, into DOM events pages can listen for:
+ *   document.addEventListener('webby:mock-api', e => console.log(e.detail));
+ */
+window.LiveReloadPluginWebbyEvents = (function() {
+	var eventPrefix = 'webby-event:';
+
+	function WebbyEvents(window, host) {
+		this.window = window;
+		this.host = host;
+	}
+
+	WebbyEvents.identifier = 'webby-events';
+	WebbyEvents.version = '1.0';
+
+	WebbyEvents.prototype.reload = function(path, options) {
+		if (path.indexOf(eventPrefix) !== 0) {
+			return false;
+		}
+
+		var detail = null;
+		try {
+			detail = JSON.parse(options.originalPath);
+		} catch (e) {}
+
+		var eventName = 'webby:' + path.substr(eventPrefix.length);
+		this.window.document.dispatchEvent(new CustomEvent(eventName, {detail: detail}));
+		return true;
+	};
+
+	return WebbyEvents;
+})();
+
+/**
+ * Shows TypeScript and JSX compile errors, sent by webby, as an overlay on the page.
+ */
+document.addEventListener('webby:compile-error', function(event) {
+	var detail = event.detail || {};
+	var overlay = document.getElementById('webby-compile-error');
+	if (!overlay) {
+		overlay = document.createElement('div');
+		overlay.id = 'webby-compile-error';
+		overlay.style.cssText = 'position:fixed;top:0;left:0;right:0;bottom:0;z-index:2147483647;overflow:auto;' +
+			'background:rgba(20,20,20,0.92);color:#EEE;font:14px/1.5 monospace;padding:32px;white-space:pre-wrap;';
+		overlay.addEventListener('click', function() {
+			overlay.parentNode.removeChild(overlay);
+		});
+		document.body.appendChild(overlay);
+	}
+
+	var lines = ['Webby could not compile ' + detail.file, ''];
+	(detail.errors || []).forEach(function(error) {
+		var location = error.file ? error.file + ':' + error.line + ':' + error.column + '\n' : '';
+		lines.push(location + error.text);
+		if (error.line_text) {
+			lines.push('    ' + error.line_text);
+		}
+		lines.push('');
+	});
+	lines.push('Click to dismiss, the page will reload once the error is fixed.');
+	overlay.textContent = lines.join('\n');
+});
@@ -12,6 +12,7 @@ import (
 	"net/url"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 
 	"github.com/fatih/color"
 )
@@ -19,8 +20,34 @@ import (
 func main() {
 	flag.Usage = usage
 	isVerbosePtr := flag.Bool("v", false, "Show verbose output")
+	proxyPtr := flag.String("proxy", "", "Proxy requests to the given URL, watching the given path for changes")
+	isHTTPSPtr := flag.Bool("https", false, "Serve over HTTPS using a local development certificate")
+	logLevelPtr := flag.String("log-level", "info", "Minimum level of messages to log: debug, info, warn or error")
+	logFormatPtr := flag.String("log-format", "text", "Format of logged messages: text or json")
+	logFilePtr := flag.String("log-file", "", "Also write log messages to the given file")
+	logMaxSizePtr := flag.Int64("log-max-size", 10, "Size, in megabytes, the log file is rotated at")
+	logBackupsPtr := flag.Int("log-backups", 3, "Number of rotated log files to keep")
+	idleTimeoutPtr := flag.Int("idle-timeout", 0, "Close servers after this many minutes without requests or connected pages")
+	exitWhenIdlePtr := flag.Int("exit-when-idle", 0, "Stop the manager after this many minutes without any servers")
+	allowedRootsPtr := flag.String("allowed-roots", "", "Folders, separated by the OS path list separator, that servers can be created for from the manager page")
 	flag.Parse()
 
+	logLevel, err := logger.ParseLevel(*logLevelPtr)
+	if err == nil {
+		err = logger.Configure(logger.Options{
+			Level:      logLevel,
+			Format:     *logFormatPtr,
+			File:       *logFilePtr,
+			MaxSize:    *logMaxSizePtr,
+			MaxBackups: *logBackupsPtr,
+		})
+	}
+	if err != nil {
+		logger.Error("Configuring logging", err)
+		return
+	}
+	defer logger.Close()
+
 	if *isVerbosePtr {
 		logger.ShowVerboseOutput()
 	}
@@ -35,40 +62,51 @@ func main() {
 	}
 
 	opts := &util.Options{
-		LiveReloadEnabled: true,
-		ManagerPort:       35729,
+		LiveReloadEnabled:  true,
+		ManagerPort:        35729,
+		HTTPSEnabled:       *isHTTPSPtr,
+		IdleTimeout:        *idleTimeoutPtr,
+		ManagerIdleTimeout: *exitWhenIdlePtr,
+		AllowedRoots:       allowedRoots(*allowedRootsPtr, inputPath),
 	}
 	portFree := util.IsPortFree(opts.ManagerPort)
 
 	var fServer *fileserver.FileServer
-	var err error
 
 	if portFree {
 		// Create a new manager server
 		var mgr = manager.NewServer(opts)
-		fServer, err = mgr.AddFileServer(inputPath)
+		if *proxyPtr != "" {
+			fServer, err = mgr.AddProxyServer(inputPath, *proxyPtr)
+		} else {
+			fServer, err = mgr.AddFileServer(inputPath)
+		}
 		if err != nil {
 			logger.Error("Adding initial file server", err)
 			return
 		}
 
-		if fServer.OpenedFile != "" {
-			urlToOpen := fmt.Sprintf("http://localhost:%d/%s", fServer.Port, fServer.OpenedFile)
+		if fServer.ProxyURL != "" {
+			_ = openWebPage(fServer.Url())
+		} else if fServer.OpenedFile != "" {
+			urlToOpen := fmt.Sprintf("%s/%s", fServer.Url(), fServer.OpenedFile)
 			_ = openWebPage(urlToOpen)
 		}
 
-		logger.Display(fmt.Sprintf("Webby Manager started at http://localhost:%d", opts.ManagerPort))
+		logger.Display(fmt.Sprintf("Webby Manager started at %s://localhost:%d", mgr.Scheme(), opts.ManagerPort))
 		err = mgr.Listen()
 	} else {
 		// Send request to add server
-		err, fServer = requestNewFileServer(opts.ManagerPort, inputPath)
+		err, fServer = requestNewFileServer(opts.ManagerPort, inputPath, *proxyPtr, opts.IdleTimeout)
 		if err != nil {
 			logger.Error("Requesting new file server on existing manager", err)
 			return
 		}
 
-		if util.IsHTMLFile(inputPath) {
-			urlToOpen := fmt.Sprintf("http://localhost:%d/%s", fServer.Port, filepath.Base(inputPath))
+		if fServer.ProxyURL != "" {
+			_ = openWebPage(fServer.Url())
+		} else if util.IsHTMLFile(inputPath) || util.IsMarkdownFile(inputPath) {
+			urlToOpen := fmt.Sprintf("%s/%s", fServer.Url(), filepath.Base(inputPath))
 			_ = openWebPage(urlToOpen)
 		}
 		logger.Display("Server already open")
@@ -79,11 +117,26 @@ func main() {
 	}
 }
 
-func requestNewFileServer(masterPort int, path string) (error, *fileserver.FileServer) {
+// allowedRoots provides the folders servers can be created for from the manager page,
+// defaulting to the folder webby was started for.
+func allowedRoots(list string, inputPath string) []string {
+	if list != "" {
+		return filepath.SplitList(list)
+	}
+	return []string{util.FormatRootPath(inputPath)}
+}
+
+func requestNewFileServer(masterPort int, path string, proxyURL string, idleTimeout int) (error, *fileserver.FileServer) {
 	localServer := fmt.Sprintf("http://127.0.0.1:%d/create-server", masterPort)
 
 	form := url.Values{}
 	form.Add("root_path", path)
+	if proxyURL != "" {
+		form.Add("proxy_url", proxyURL)
+	}
+	if idleTimeout > 0 {
+		form.Add("idle_timeout", strconv.Itoa(idleTimeout))
+	}
 	resp, err := http.PostForm(localServer, form)
 	if err != nil {
 		return err, nil
@@ -108,8 +161,17 @@ func usage() {
 	color.Blue("Examples:")
 	color.Cyan("  webby ./ 		# Starts a file server in the current directory")
 	color.Cyan("  webby test.html 	# As above and opens up test.html in the browser")
+	color.Cyan("  webby -proxy http://localhost:8080 ./ 	# Proxies to a backend, reloading on changes in the current directory")
 	fmt.Println("")
 	color.Blue("Options:")
 	color.Cyan("  -v 		# Show verbose output")
+	color.Cyan("  -proxy <url> 	# Proxy requests to the given URL instead of serving files")
+	color.Cyan("  -https 	# Serve over HTTPS using a local development certificate")
+	color.Cyan("  -log-level <level> 	# Only log messages at or above debug, info, warn or error")
+	color.Cyan("  -log-format json 	# Log messages as JSON lines instead of text")
+	color.Cyan("  -log-file <path> 	# Also write log messages to a file, rotated by size")
+	color.Cyan("  -idle-timeout <mins> 	# Close servers left idle for the given minutes")
+	color.Cyan("  -exit-when-idle <mins> 	# Stop webby after having no servers for the given minutes")
+	color.Cyan("  -allowed-roots <paths> 	# Limit the folders servers can be created for from the manager page")
 	flag.PrintDefaults()
 }